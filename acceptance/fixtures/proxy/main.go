@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -10,7 +10,9 @@ import (
 )
 
 // This is a dummy reverse proxy to illustrate how our services can access the acceptance tester
+// It logs JSON lines, which aceptadora decodes as the service defines `log_format: json`
 func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 	port := os.Getenv("PROXY_PORT")
 	targetURLRaw := os.Getenv("PROXY_TARGETURL")
 
@@ -24,8 +26,11 @@ func main() {
 		panic(err)
 	}
 	reverseProxy := httputil.NewSingleHostReverseProxy(targetURL)
-	mux.Handle("/", reverseProxy)
+	mux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		logger.Info("Proxying request", "path", req.URL.Path)
+		reverseProxy.ServeHTTP(rw, req)
+	})
 
-	log.Printf("Starting proxy on 0.0.0.0:%s for %s", port, targetURLRaw)
+	logger.Info("Starting proxy", "port", port, "target", targetURLRaw)
 	http.ListenAndServe(fmt.Sprintf(":%s", port), mux)
 }
@@ -1,10 +1,15 @@
 package suite
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,10 +23,6 @@ const expectedMockedDependencyInventedHTTPStatusCode = 288
 type Config struct {
 	Aceptadora  aceptadora.Config
 	ImagePuller aceptadora.ImagePullerConfig
-
-	// ServicesAddress is the address where services started by aceptadora can be found
-	// It differs from env to env, and it's set up in env-specific configs
-	ServicesAddress string
 }
 
 type acceptanceSuite struct {
@@ -31,6 +32,7 @@ type acceptanceSuite struct {
 	aceptadora *aceptadora.Aceptadora
 
 	mockedDependencyListener net.Listener
+	mockedDependencyProxy    *aceptadora.Proxy
 }
 
 func (s *acceptanceSuite) SetupSuite() {
@@ -52,25 +54,218 @@ func (s *acceptanceSuite) SetupSuite() {
 	s.aceptadora.PullImages(ctx)
 
 	s.startMockedProxyDependency()
+	// proxy service will reach our mocked dependency through this proxy, so we can inject faults
+	s.mockedDependencyProxy = s.aceptadora.ProxyTester("MOCKED_DEPENDENCY_PROXY_PORT", 8000)
 
-	s.aceptadora.Run(ctx, "redis")
-	s.Require().Eventually(func() bool {
-		return tcpConnectionIsAccepted(s.cfg.ServicesAddress, 6379)
-	}, time.Minute, 50*time.Millisecond, "redis didn't start")
-
-	s.aceptadora.Run(ctx, "proxy")
-	s.Require().Eventually(func() bool {
-		return httpHealthcheckSucceeds(s.cfg.ServicesAddress, 8888)
-	}, time.Minute, 50*time.Millisecond, "proxy didn't start")
+	// services are ready once RunAll returns, as they define their probes in `wait_for`
+	// redis is started before proxy, as proxy depends on it
+	s.aceptadora.RunAll(ctx, "proxy")
 }
 
 func (s *acceptanceSuite) TestProxyCall() {
 	// we call the proxy on some path, and proxy will call us, so we should see the same status code
-	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s:8888/some/random/path", s.cfg.ServicesAddress))
+	// proxy's port is published on a random host port, so we ask aceptadora where to find it
+	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s/some/random/path", s.aceptadora.Endpoint("proxy", "8888/tcp")))
 	s.Require().NoError(err)
 	s.Require().Equal(expectedMockedDependencyInventedHTTPStatusCode, resp.StatusCode)
 }
 
+func (s *acceptanceSuite) TestRunSameServiceUnderAnotherName() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// services run through a child aceptadora are stopped when this test finishes,
+	// while the services of the suite are still visible through it
+	a := s.aceptadora.ForTest(s.T())
+
+	// redis publishes its port on a random host port, so we can run another instance of it
+	a.Run(ctx, "redis",
+		aceptadora.WithContainerName("another-redis"),
+		aceptadora.WithCommand("redis-server", "--appendonly", "no"),
+	)
+
+	s.Require().NotEqual(a.Endpoint("redis", "6379"), a.Endpoint("another-redis", "6379"))
+	conn, err := net.Dial("tcp", a.Endpoint("another-redis", "6379"))
+	s.Require().NoError(err)
+	s.Require().NoError(conn.Close())
+}
+
+func (s *acceptanceSuite) TestExecInService() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	res := s.aceptadora.Exec(ctx, "redis", "redis-cli", "SET", "greeting", "hello")
+	s.Require().Equal(0, res.ExitCode, res.Stderr)
+
+	res = s.aceptadora.ExecWithConfig(ctx, "redis", aceptadora.ExecConfig{
+		Cmd:   []string{"redis-cli", "-x", "SET", "piped"},
+		Stdin: strings.NewReader("from stdin"),
+	})
+	s.Require().Equal(0, res.ExitCode, res.Stderr)
+
+	res = s.aceptadora.Exec(ctx, "redis", "redis-cli", "MGET", "greeting", "piped")
+	s.Require().Equal("hello\nfrom stdin\n", res.Stdout)
+
+	res = s.aceptadora.ExecWithConfig(ctx, "redis", aceptadora.ExecConfig{
+		Cmd:        []string{"sh", "-c", "echo $GREETING from $(pwd) >&2; exit 3"},
+		Env:        map[string]string{"GREETING": "hi"},
+		WorkingDir: "/tmp",
+	})
+	s.Require().Equal(3, res.ExitCode)
+	s.Require().Equal("hi from /tmp\n", res.Stderr)
+}
+
+func (s *acceptanceSuite) TestCopyToAndFromService() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dir := s.T().TempDir()
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "original.txt"), []byte("some content"), 0o644))
+
+	s.aceptadora.CopyTo(ctx, "redis", filepath.Join(dir, "original.txt"), "/tmp/copied.txt")
+	res := s.aceptadora.Exec(ctx, "redis", "cat", "/tmp/copied.txt")
+	s.Require().Equal("some content", res.Stdout)
+
+	s.aceptadora.CopyFrom(ctx, "redis", "/tmp/copied.txt", filepath.Join(dir, "copied-back.txt"))
+	content, err := os.ReadFile(filepath.Join(dir, "copied-back.txt"))
+	s.Require().NoError(err)
+	s.Require().Equal("some content", string(content))
+}
+
+func (s *acceptanceSuite) TestServiceLifecycle() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	s.aceptadora.Run(ctx, "redis", aceptadora.WithContainerName("lifecycle-redis"))
+	defer s.aceptadora.Stop(ctx, "lifecycle-redis")
+
+	s.aceptadora.Pause(ctx, "lifecycle-redis")
+	s.aceptadora.Unpause(ctx, "lifecycle-redis")
+	s.Require().Equal("PONG\n", s.aceptadora.Exec(ctx, "lifecycle-redis", "redis-cli", "ping").Stdout)
+
+	// a killed service can be restarted
+	s.aceptadora.Kill(ctx, "lifecycle-redis", "SIGKILL")
+	s.aceptadora.Restart(ctx, "lifecycle-redis")
+	s.Require().Equal("PONG\n", s.aceptadora.Exec(ctx, "lifecycle-redis", "redis-cli", "ping").Stdout)
+
+	// and so does a stopped one
+	s.aceptadora.Stop(ctx, "lifecycle-redis")
+	s.aceptadora.Restart(ctx, "lifecycle-redis")
+	s.Require().Equal("PONG\n", s.aceptadora.Exec(ctx, "lifecycle-redis", "redis-cli", "ping").Stdout)
+}
+
+func (s *acceptanceSuite) TestNetworkPartition() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	s.aceptadora.Run(ctx, "redis", aceptadora.WithContainerName("redis-a"))
+	defer s.aceptadora.Stop(ctx, "redis-a")
+	s.aceptadora.Run(ctx, "redis", aceptadora.WithContainerName("redis-b"))
+	defer s.aceptadora.Stop(ctx, "redis-b")
+
+	pingFromAToB := func() string {
+		return s.aceptadora.Exec(ctx, "redis-a", "redis-cli", "-h", "redis-b", "ping").Stdout
+	}
+	s.Require().Equal("PONG\n", pingFromAToB())
+
+	s.aceptadora.Partition(ctx, []string{"redis-a"}, []string{"redis-b"})
+	s.Require().NotEqual("PONG\n", pingFromAToB())
+
+	s.aceptadora.Heal(ctx)
+	s.Require().Equal("PONG\n", pingFromAToB())
+
+	s.aceptadora.Disconnect(ctx, "redis-b", "")
+	s.Require().NotEqual("PONG\n", pingFromAToB())
+
+	s.aceptadora.Reconnect(ctx, "redis-b", "")
+	s.Require().Equal("PONG\n", pingFromAToB())
+}
+
+func (s *acceptanceSuite) TestProxyCallWithLatencyToTester() {
+	const latency = 300 * time.Millisecond
+	s.mockedDependencyProxy.SetToxics(aceptadora.Toxics{Latency: latency})
+	defer s.mockedDependencyProxy.SetToxics(aceptadora.Toxics{})
+
+	t0 := time.Now()
+	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s/some/random/path", s.aceptadora.Endpoint("proxy", "8888/tcp")))
+	s.Require().NoError(err)
+	s.Require().Equal(expectedMockedDependencyInventedHTTPStatusCode, resp.StatusCode)
+	s.Require().GreaterOrEqual(time.Since(t0), latency)
+}
+
+func (s *acceptanceSuite) TestRedisConnectionReset() {
+	proxy := s.aceptadora.Proxy("redis", "6379")
+	defer proxy.SetToxics(aceptadora.Toxics{})
+
+	conn, err := net.Dial("tcp", proxy.Addr())
+	s.Require().NoError(err)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("PING\r\n"))
+	s.Require().NoError(err)
+	line, err := reader.ReadString('\n')
+	s.Require().NoError(err)
+	s.Require().Equal("+PONG\r\n", line)
+
+	proxy.SetToxics(aceptadora.Toxics{ResetPeer: true})
+	_, err = reader.ReadString('\n')
+	s.Require().Error(err)
+
+	stats := proxy.Stats()
+	last := stats[len(stats)-1]
+	s.Require().Equal(int64(len("PING\r\n")), last.BytesSent)
+	s.Require().Equal(int64(len("+PONG\r\n")), last.BytesReceived)
+	s.Require().False(last.Closed.IsZero())
+}
+
+func (s *acceptanceSuite) TestLogsAreWrittenIntoArtifacts() {
+	data, err := os.ReadFile(filepath.Join(s.cfg.Aceptadora.ArtifactsDir, "index.json"))
+	s.Require().NoError(err)
+	var index aceptadora.ArtifactsIndex
+	s.Require().NoError(json.Unmarshal(data, &index))
+
+	// redis ignores its logs, but they're still written into the artifacts
+	for _, svc := range index.Services {
+		if svc.Service != "redis" {
+			continue
+		}
+		s.Require().Eventually(func() bool {
+			logs, err := os.ReadFile(filepath.Join(s.cfg.Aceptadora.ArtifactsDir, svc.Stdout))
+			return err == nil && strings.Contains(string(logs), "Ready to accept connections")
+		}, 10*time.Second, 100*time.Millisecond)
+		return
+	}
+	s.Fail("redis is not in the artifacts index", "%+v", index)
+}
+
+func (s *acceptanceSuite) TestWaitForLogAndAssertNoLog() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// redis logged this line while starting, way before this test started, and it ignores its logs, but they're still kept
+	line := s.aceptadora.WaitForLog(ctx, "redis", "Ready to accept connections", aceptadora.Stdout)
+	s.Contains(line, "Ready to accept connections")
+
+	s.aceptadora.AssertNoLog("redis", "(?i)panic|fatal")
+}
+
+func (s *acceptanceSuite) TestProxyLogsAreDecoded() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s/decoded/log", s.aceptadora.Endpoint("proxy", "8888/tcp")))
+	s.Require().NoError(err)
+	resp.Body.Close()
+
+	// proxy logs JSON lines, which are decoded as it defines `log_format: json`
+	entry := s.aceptadora.WaitForLogEntry(ctx, "proxy", aceptadora.LogEntryField("path", "/decoded/log"))
+	s.Equal("INFO", entry.Level)
+	s.Equal("Proxying request", entry.Message)
+
+	s.aceptadora.AssertNoLogEntry("proxy", aceptadora.LogEntryLevel("error"))
+}
+
 func (s *acceptanceSuite) TearDownSuite() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
@@ -98,29 +293,3 @@ func (s *acceptanceSuite) startMockedProxyDependency() {
 func TestAcceptanceSuite(t *testing.T) {
 	suite.Run(t, new(acceptanceSuite))
 }
-
-func tcpConnectionIsAccepted(host string, port int) bool {
-	addr := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return false
-	}
-	conn.Close()
-	return true
-}
-
-// httpHealthcheckSucceeds will return true if the /status endpoint on a given host/port returns a 200 status code to a GET request
-func httpHealthcheckSucceeds(host string, port int) bool {
-	url := fmt.Sprintf("http://%s:%d/status", host, port)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		panic(fmt.Errorf("can't build http request for healthcheck, maybe wrong config? %s", err))
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false
-	}
-	resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
-}
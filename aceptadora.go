@@ -2,8 +2,9 @@ package aceptadora
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
-	"os"
 	"testing"
 	"time"
 
@@ -16,94 +17,329 @@ type Config struct {
 	YAMLDir  string `default:"./"`
 	YAMLName string `default:"aceptadora.yml"`
 
+	// SessionID, if provided, prefixes the names of the containers and the networks created by aceptadora,
+	// so suites running in parallel on the same docker host don't clobber each other.
+	// Services can still reach each other by their plain names, as those are kept as aliases in the session's network.
+	SessionID string `default:""`
+
+	// ServicesAddress is the address where the tester can reach the ports published by the services.
+	// Usually this is the localhost, but it can be different, for instance when running with docker-in-docker.
+	ServicesAddress string `default:"127.0.0.1"`
+
+	// PullConcurrency is the maximum amount of images pulled at the same time by PullImages.
+	PullConcurrency int `default:"4"`
+
 	// StopTimeout will be used to stop containers gracefully.
 	// If zero (default), then containers will be forced to stop immediately saving some tear down time.
 	StopTimeout time.Duration `default:"0s"`
+
+	// ArtifactsDir, if provided, is the directory where the stdout and stderr of each service are written
+	// into separate files with timestamps, even for services with `ignore_logs`.
+	// An index.json file listing them is written too, so they can be easily collected by the CI.
+	ArtifactsDir string `default:""`
+
+	// LogMode defines when the logs of the services are sent to the test logs, unless they define their own `log_mode`.
+	// It can be `always` (default), `on_failure` or `never`.
+	LogMode LogMode `default:"always"`
+	// LogBufferSize is the amount of lines of each service kept when LogMode is `on_failure`,
+	// unless they define their own `log_buffer_size`.
+	LogBufferSize int `default:"1000"`
+	// LogHistorySize is the amount of lines of each service kept for WaitForLog, AssertNoLog and LogEntries,
+	// unless they define their own `log_history_size`. The oldest lines are dropped once it's reached.
+	LogHistorySize int `default:"10000"`
+
+	// LogColors colorizes the logs of each service with a different color.
+	LogColors bool `default:"false"`
+
+	// KeepOnFailure leaves the services running when StopAll is called after the test failed,
+	// logging how to inspect them, so the evidence isn't destroyed.
+	// It can also be enabled with the ACEPTADORA_KEEP_ON_FAILURE env var.
+	KeepOnFailure bool `default:"false"`
+
+	// Reaper configures the optional reaper that removes the containers and networks once the test process ends,
+	// even if it panics, times out or is killed.
+	Reaper ReaperConfig
 }
 
+// cleanupTimeout is the time given to the services to stop when the test finishes
+const cleanupTimeout = time.Minute
+
+// Aceptadora runs the services defined in aceptadora.yml for a test, failing the test if something goes wrong.
+// It's a thin wrapper around Core, which can be used instead when there's no test to fail, like in TestMain.
+// All its methods are safe for concurrent use, so it can be shared by parallel subtests.
 type Aceptadora struct {
-	cfg     Config
-	t       *testing.T
+	t       testing.TB
 	require *require.Assertions
+	core    *Core
 
-	yaml YAML
-
-	imagePuller ImagePuller
-
-	services map[string]*Runner
-	order    []string
+	// scope tracks the services run by a child created by ForTest, it's nil for the one created by New
+	scope *scope
 }
 
 // New creates a new Aceptadora. It will try to load the YAML config from the path provided by Config
-// If something goes wrong, it will use testing.T to fail.
-func New(t *testing.T, imagePuller ImagePuller, cfg Config) *Aceptadora {
-	if _, ok := os.LookupEnv("TESTER_ADDRESS"); !ok {
-		os.Setenv("TESTER_ADDRESS", getLocalIP())
+// If something goes wrong, it will use testing.TB to fail.
+// StopAll is called automatically when the test finishes, so calling it explicitly is only needed to stop the services earlier.
+func New(t testing.TB, imagePuller ImagePuller, cfg Config) *Aceptadora {
+	core, err := NewCore(t, corePuller(imagePuller), cfg)
+	require.NoError(t, err, "Can't create aceptadora: %s", err)
+	// the logs buffered by the services with log_mode on_failure are flushed when the test finishes, in case they're not stopped
+	t.Cleanup(core.flushLogBuffers)
+
+	a := &Aceptadora{
+		t:       t,
+		require: require.New(t),
+		core:    core,
 	}
+	t.Cleanup(a.cleanup)
+	return a
+}
 
-	os.Setenv("YAMLDIR", cfg.YAMLDir)
-	yamlPath := cfg.YAMLDir + "/" + cfg.YAMLName
-	yaml, err := LoadYAML(yamlPath)
-	require.NoError(t, err, "Can't load YAML from %q: %s", yamlPath, err)
-
-	return &Aceptadora{
-		t:           t,
-		require:     require.New(t),
-		cfg:         cfg,
-		yaml:        yaml,
-		imagePuller: imagePuller,
-		services:    map[string]*Runner{},
+// ForTest returns a child of this Aceptadora for the provided test, like a subtest.
+// The services run through the child are stopped when that test finishes, and their logs are written into its logs,
+// while the services run by the parent (like the ones shared by a suite) remain visible through the child.
+// Config.KeepOnFailure doesn't apply to the services of the child, as they can't log into a test that has finished.
+func (a *Aceptadora) ForTest(t testing.TB) *Aceptadora {
+	child := &Aceptadora{
+		t:       t,
+		require: require.New(t),
+		core:    a.core,
+		scope:   &scope{log: t},
 	}
+	t.Cleanup(child.cleanup)
+	return child
+}
+
+// cleanup stops the services when the test finishes
+func (a *Aceptadora) cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	a.StopAll(ctx)
+}
+
+// Core returns the error-returning Core this Aceptadora wraps
+func (a *Aceptadora) Core() *Core {
+	return a.core
 }
 
-// PullImages pulls all the images mentioned in aceptadora.yml
+// PullImages pulls the images of the services provided, or all the images mentioned in aceptadora.yml if none is provided.
 // This allows doing this outside of the context of the test, and avoid unrelated flaky timeouts in the tests
 // happening when most of the context has been consumed by pulling the image
-func (a *Aceptadora) PullImages(ctx context.Context) {
-	for _, svc := range a.yaml.Services {
-		a.imagePuller.Pull(ctx, svc.Image)
-	}
+// Up to Config.PullConcurrency images are pulled concurrently, and all the images that can't be pulled are reported together.
+func (a *Aceptadora) PullImages(ctx context.Context, names ...string) {
+	err := a.core.PullImages(ctx, names...)
+	a.require.NoError(err, "Can't pull images: %s", err)
 }
 
-// Run will start a given service (from aceptadora.yml) and register it for stopping later
-func (a *Aceptadora) Run(ctx context.Context, name string) {
-	if _, ok := a.yaml.Services[name]; !ok {
-		a.t.Fatalf("There's no service with name %q", name)
-	}
-	if _, ok := a.services[name]; ok {
-		a.t.Fatalf("Trying to start again the service %q", name)
-	}
+// Run will start a given service (from aceptadora.yml), wait until it's ready and register it for stopping later
+// The RunOptions provided can modify the service definition for this run.
+// The service is registered with its container name, which is the name of the service unless WithContainerName
+// or `container_name` are used, so the same service can be run several times under different names.
+// A service that was stopped can be run again, in which case a new container will replace the previous one.
+func (a *Aceptadora) Run(ctx context.Context, name string, opts ...RunOption) {
+	err := a.core.run(ctx, a.scope, name, opts...)
+	a.require.NoError(err, "Can't run %q: %s", name, err)
+}
+
+// Endpoint returns the address where the tester can reach the given container port of a running service.
+// The port is a container port like `6379/tcp`, and `tcp` is assumed if the protocol is not provided.
+// This is useful for ports defined without a host port (like `- 6379`), which are published on a random host port.
+func (a *Aceptadora) Endpoint(name, port string) string {
+	endpoint, err := a.core.Endpoint(name, port)
+	a.require.NoError(err, "Can't get the endpoint of port %s of %q: %s", port, name, err)
+	return endpoint
+}
+
+// Exec executes the provided command inside of the container of a running service and waits until it finishes.
+// A non-zero exit code is not considered a failure, it's up to the caller to check it.
+func (a *Aceptadora) Exec(ctx context.Context, name string, cmd ...string) ExecResult {
+	return a.ExecWithConfig(ctx, name, ExecConfig{Cmd: cmd})
+}
+
+// ExecWithConfig executes the command defined by the provided config inside of the container of a running service,
+// allowing to provide the env, working dir, user and stdin of the command.
+func (a *Aceptadora) ExecWithConfig(ctx context.Context, name string, cfg ExecConfig) ExecResult {
+	res, err := a.core.ExecWithConfig(ctx, name, cfg)
+	a.require.NoError(err, "Can't exec %v in %q: %s", cfg.Cmd, name, err)
+	return res
+}
+
+// CopyTo copies the file or directory at hostPath to containerPath in the container of a running service.
+// It uses the docker API, so unlike binds it works when the docker daemon can't see the host's filesystem.
+func (a *Aceptadora) CopyTo(ctx context.Context, name, hostPath, containerPath string) {
+	err := a.core.CopyTo(ctx, name, hostPath, containerPath)
+	a.require.NoError(err, "Can't copy %q to %q in %q: %s", hostPath, containerPath, name, err)
+}
+
+// CopyFrom copies the file or directory at containerPath in the container of a running service to hostPath.
+func (a *Aceptadora) CopyFrom(ctx context.Context, name, containerPath, hostPath string) {
+	err := a.core.CopyFrom(ctx, name, containerPath, hostPath)
+	a.require.NoError(err, "Can't copy %q from %q to %q: %s", containerPath, name, hostPath, err)
+}
 
-	runner := NewRunner(a.t, name, a.yaml.Services[name], a.imagePuller)
-	runner.Start(ctx)
-	a.services[name] = runner
-	a.order = append(a.order, name)
+// WaitForLog waits until the service logs a line matching the regexp pattern on any of the provided streams,
+// or on any stream if none is provided, and returns that line.
+// The lines logged before calling it are matched too, so it doesn't matter if the line was logged already.
+func (a *Aceptadora) WaitForLog(ctx context.Context, name, pattern string, streams ...LogStream) string {
+	line, err := a.core.WaitForLog(ctx, name, pattern, streams...)
+	a.require.NoError(err, "Service %q didn't log a line matching %q: %s", name, pattern, err)
+	return line
 }
 
-// StopAll will stop all the services in the reverse order
+// AssertNoLog asserts that the service hasn't logged any line matching the regexp pattern on any of the provided streams,
+// or on any stream if none is provided, failing the test (but not stopping it) otherwise.
+// It returns true if no line matched.
+func (a *Aceptadora) AssertNoLog(name, pattern string, streams ...LogStream) bool {
+	match, err := logMatcher(pattern, streams)
+	a.require.NoError(err, "Invalid pattern %q: %s", pattern, err)
+	return a.assertNoLogEntry(name, match, fmt.Sprintf("matching %q", pattern))
+}
+
+// WaitForLogEntry waits until the service logs an entry for which match returns true, and returns that entry.
+// The entries logged before calling it are matched too.
+// This is useful with the entries decoded according to the `log_format`, like WaitForLogEntry(ctx, name, LogEntryField("event", "started")).
+func (a *Aceptadora) WaitForLogEntry(ctx context.Context, name string, match func(LogEntry) bool) LogEntry {
+	e, err := a.core.WaitForLogEntry(ctx, name, match)
+	a.require.NoError(err, "Service %q didn't log the expected entry: %s", name, err)
+	return e
+}
+
+// AssertNoLogEntry asserts that the service hasn't logged any entry for which match returns true,
+// like AssertNoLogEntry(name, LogEntryLevel("error")), failing the test (but not stopping it) otherwise.
+// It returns true if no entry matched.
+func (a *Aceptadora) AssertNoLogEntry(name string, match func(LogEntry) bool) bool {
+	return a.assertNoLogEntry(name, match, "matching")
+}
+
+func (a *Aceptadora) assertNoLogEntry(name string, match func(LogEntry) bool, description string) bool {
+	found, err := a.core.LogEntries(name, match)
+	a.require.NoError(err, "Can't assert the logs of %q: %s", name, err)
+	return assertNoLogEntries(a.t, name, found, description)
+}
+
+// LogEntries returns all the entries logged by the service for which match returns true, or all of them if match is nil.
+func (a *Aceptadora) LogEntries(name string, match func(LogEntry) bool) []LogEntry {
+	entries, err := a.core.LogEntries(name, match)
+	a.require.NoError(err, "Can't get the logs of %q: %s", name, err)
+	return entries
+}
+
+// RunAll will start the services provided (or all the services from aceptadora.yml if none is provided)
+// together with all their transitive dependencies declared in `depends_on`.
+// Each service is started as soon as all its dependencies have reached their conditions,
+// so independent services are started concurrently.
+// Services that were already running are not started again, but the conditions on them are still checked.
+// Since services are registered once they're started, StopAll will stop them in reverse topological order.
+func (a *Aceptadora) RunAll(ctx context.Context, names ...string) {
+	err := a.core.runAll(ctx, a.scope, names)
+	a.require.NoError(err, "Can't run %v: %s", names, err)
+}
+
+// StopAll will stop all the services in the reverse order, and then close all the proxies.
 // If you need to explicitly stop some service in first place, use Stop() previously.
+// If the test failed and Config.KeepOnFailure is enabled, the services are left running instead,
+// and a summary of how to inspect them is logged.
+// It's called automatically when the test finishes.
+// On a child created by ForTest, it only stops the services run through the child.
 func (a *Aceptadora) StopAll(ctx context.Context) {
-	for i := len(a.order) - 1; i >= 0; i-- {
-		a.Stop(ctx, a.order[i])
+	var err error
+	if a.scope != nil {
+		err = a.core.stopScope(ctx, a.scope)
+	} else {
+		err = a.core.StopAll(ctx)
 	}
+	assert.NoError(a.t, err, "Can't stop all the services: %s", err)
 }
 
 // Stop will try to stop the service with the name provided
 // It will fail fatally if such service isn't defined
-// It will skip the service if it's already stopped, and set it to nil once stopped, making this call idempotent
-// Stop is not thread safe.
+// It will skip the service if it's already stopped, making this call idempotent
 func (a *Aceptadora) Stop(ctx context.Context, name string) {
-	svc, ok := a.services[name]
-	if !ok {
+	err := a.core.Stop(ctx, name)
+	if errors.Is(err, ErrServiceNotFound) {
 		a.t.Fatalf("There's no service %q to stop", name)
 	}
-	if svc == nil {
-		return
-	}
+	assert.NoError(a.t, err, "Can't stop service %q in time: %s", name, err)
+}
+
+// Restart will stop the service with the name provided (unless it's already stopped) and start the same container again,
+// waiting until it's ready.
+// It will fail fatally if such service was never run.
+func (a *Aceptadora) Restart(ctx context.Context, name string) {
+	err := a.core.Restart(ctx, name)
+	a.require.NoError(err, "Can't restart %q: %s", name, err)
+}
+
+// Pause suspends all the processes of a running service.
+func (a *Aceptadora) Pause(ctx context.Context, name string) {
+	err := a.core.Pause(ctx, name)
+	a.require.NoError(err, "Can't pause %q: %s", name, err)
+}
+
+// Unpause resumes all the processes of a paused service.
+func (a *Aceptadora) Unpause(ctx context.Context, name string) {
+	err := a.core.Unpause(ctx, name)
+	a.require.NoError(err, "Can't unpause %q: %s", name, err)
+}
+
+// Kill sends the provided signal, like "SIGTERM" or "SIGHUP", to a running service.
+// If the signal is empty, "SIGKILL" is sent.
+// If the service exits because of the signal, it can be started again with Restart.
+func (a *Aceptadora) Kill(ctx context.Context, name, signal string) {
+	err := a.core.Kill(ctx, name, signal)
+	a.require.NoError(err, "Can't send signal %q to %q: %s", signal, name, err)
+}
+
+// Disconnect disconnects a running service from the provided network (prefixed by the session if there's one),
+// or from its own network if it's empty, so it can't reach the other services on that network, nor be reached by them.
+// The tester can still reach the ports published by the service.
+func (a *Aceptadora) Disconnect(ctx context.Context, name, network string) {
+	err := a.core.Disconnect(ctx, name, network)
+	a.require.NoError(err, "Can't disconnect %q: %s", name, err)
+}
+
+// Reconnect connects again a running service to the provided network (prefixed by the session if there's one),
+// or to its own network if it's empty.
+func (a *Aceptadora) Reconnect(ctx context.Context, name, network string) {
+	err := a.core.Reconnect(ctx, name, network)
+	a.require.NoError(err, "Can't reconnect %q: %s", name, err)
+}
+
+// Partition isolates the services of groupA from the services of groupB.
+// Each group is moved from its own network to a network created for the group,
+// so the services of the same group can still reach each other, while they can't reach the services of the other group.
+// Services that are not part of any group can't reach the partitioned services either.
+// The tester can still reach the ports published by all of them.
+// Use Heal to restore the networks of all the partitioned services.
+func (a *Aceptadora) Partition(ctx context.Context, groupA, groupB []string) {
+	err := a.core.Partition(ctx, groupA, groupB)
+	a.require.NoError(err, "Can't partition %v from %v: %s", groupA, groupB, err)
+}
+
+// Heal restores the networks of all the services partitioned by Partition,
+// and removes the networks created for their groups.
+func (a *Aceptadora) Heal(ctx context.Context) {
+	err := a.core.Heal(ctx)
+	a.require.NoError(err, "Can't heal the partitions: %s", err)
+}
+
+// Proxy returns the proxy through which the tester reaches the given container port of a service,
+// which should be listed in the `proxied_ports` of the service.
+// The proxy keeps working if the service is restarted, even if its port is published on a different host port.
+func (a *Aceptadora) Proxy(name, port string) *Proxy {
+	p, err := a.core.Proxy(name, port)
+	a.require.NoError(err, "Can't get the proxy: %s", err)
+	return p
+}
 
-	err := svc.StopWithTimeout(ctx, a.cfg.StopTimeout)
-	assert.NoError(a.t, err, "Can't stop service %q in time: %s", err)
-	a.services[name] = nil
+// ProxyTester starts a proxy through which the services reach the given port of the tester,
+// listening on a random port of TESTER_ADDRESS, and sets the env var provided to that port.
+// Env vars are expanded in env files when the containers are created,
+// so they can reach the tester through the proxy at `${TESTER_ADDRESS}:${envVar}`.
+// Calling it again with the same env var closes the previous proxy.
+func (a *Aceptadora) ProxyTester(envVar string, port int) *Proxy {
+	p, err := a.core.ProxyTester(envVar, port)
+	a.require.NoError(err, "Can't proxy the tester: %s", err)
+	return p
 }
 
 // getLocalIP returns the non loopback local IP of the host
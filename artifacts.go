@@ -0,0 +1,102 @@
+package aceptadora
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// artifactsIndexName is the name of the file listing the artifacts written into Config.ArtifactsDir
+const artifactsIndexName = "index.json"
+
+// ArtifactsIndex lists the log files written into Config.ArtifactsDir.
+// It's written as index.json into that directory.
+type ArtifactsIndex struct {
+	Services []ServiceArtifacts `json:"services"`
+}
+
+// ServiceArtifacts lists the log files written for a service, relative to Config.ArtifactsDir
+type ServiceArtifacts struct {
+	Service     string `json:"service"`
+	Container   string `json:"container"`
+	ContainerID string `json:"container_id"`
+	Image       string `json:"image"`
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+}
+
+// artifactName returns the name of the file the given stream of the container is written to
+func (r *Runner) artifactName(stream LogStream) string {
+	return fmt.Sprintf("%s.%s.log", r.containerName(), stream)
+}
+
+// openArtifacts opens the files where the logs of the container are written, if there's an artifacts dir.
+// Files are appended to, so the logs of previous runs of the same container aren't lost.
+func (r *Runner) openArtifacts() error {
+	if r.artifactsDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(r.artifactsDir, 0o755); err != nil {
+		return err
+	}
+	r.artifacts = map[LogStream]*os.File{}
+	for _, stream := range []LogStream{Stdout, Stderr} {
+		f, err := os.OpenFile(filepath.Join(r.artifactsDir, r.artifactName(stream)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			r.closeArtifacts()
+			return err
+		}
+		r.artifacts[stream] = f
+	}
+	return nil
+}
+
+// writeArtifact writes the line logged by the container into the file of its stream, prefixed by the current time
+func (r *Runner) writeArtifact(stream LogStream, line string) {
+	f, ok := r.artifacts[stream]
+	if !ok {
+		return
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", time.Now().UTC().Format(time.RFC3339Nano), line); err != nil {
+		r.log.Logf("Can't write logs of %q into %q, not writing them anymore: %s", r.name, f.Name(), err)
+		r.closeArtifacts()
+	}
+}
+
+// closeArtifacts closes the files where the logs of the container are written
+func (r *Runner) closeArtifacts() {
+	for stream, f := range r.artifacts {
+		if err := f.Close(); err != nil {
+			r.log.Logf("Can't close %q: %s", f.Name(), err)
+		}
+		delete(r.artifacts, stream)
+	}
+}
+
+// writeArtifactsIndex writes the index of the log files of all the services run, if there's an artifacts dir.
+// It should be called while holding c.mtx.
+func (c *Core) writeArtifactsIndex() error {
+	if c.cfg.ArtifactsDir == "" {
+		return nil
+	}
+
+	var index ArtifactsIndex
+	for _, name := range c.order {
+		r := c.services[name]
+		index.Services = append(index.Services, ServiceArtifacts{
+			Service:     r.name,
+			Container:   r.containerName(),
+			ContainerID: r.container.ID,
+			Image:       r.svc.Image,
+			Stdout:      r.artifactName(Stdout),
+			Stderr:      r.artifactName(Stderr),
+		})
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.cfg.ArtifactsDir, artifactsIndexName), data, 0o644)
+}
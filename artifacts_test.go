@@ -0,0 +1,107 @@
+package aceptadora
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCore_WritesTheArtifacts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	docker.startLogs = func(name string) []LogEntry {
+		return []LogEntry{{Stream: Stdout, Line: name + " out"}, {Stream: Stderr, Line: name + " err"}}
+	}
+	cfg := newTestConfigWithYAML(t, `services:
+  api:
+    image: docker.io/library/api:latest
+  db:
+    image: docker.io/library/db:latest
+    ignore_logs: true
+`)
+	cfg.ArtifactsDir = filepath.Join(t.TempDir(), "artifacts")
+	log := &recordingLogger{}
+	core, err := NewCore(log, newFakePuller(), cfg)
+	require.NoError(t, err)
+	core.newClient = docker.client
+
+	// waitForLines waits until the service has logged the expected amount of lines on stderr, which are logged last
+	waitForLines := func(name string, expected int) {
+		require.Eventually(t, func() bool {
+			entries, err := core.LogEntries(name, func(e LogEntry) bool { return e.Stream == Stderr })
+			return err == nil && len(entries) == expected
+		}, 5*time.Second, 10*time.Millisecond, "%s didn't log %d lines", name, expected)
+	}
+
+	require.NoError(t, core.Run(ctx, "db"))
+	require.NoError(t, core.Run(ctx, "api"))
+	waitForLines("db", 1)
+	waitForLines("api", 1)
+	// api is restarted, and the logs of both runs are kept
+	require.NoError(t, core.Restart(ctx, "api"))
+	waitForLines("api", 2)
+	require.NoError(t, core.StopAll(ctx))
+	assert.Empty(t, log.containing("db out"), "ignore_logs services are not logged in the test")
+	assert.Len(t, log.containing("api out"), 2)
+
+	for _, tc := range []struct {
+		file          string
+		expectedLines []string
+	}{
+		{file: "api.stdout.log", expectedLines: []string{"api out", "api out"}},
+		{file: "api.stderr.log", expectedLines: []string{"api err", "api err"}},
+		{file: "db.stdout.log", expectedLines: []string{"db out"}},
+		{file: "db.stderr.log", expectedLines: []string{"db err"}},
+	} {
+		data, err := os.ReadFile(filepath.Join(cfg.ArtifactsDir, tc.file))
+		require.NoError(t, err, tc.file)
+
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			timestamp, logged, ok := strings.Cut(line, " ")
+			require.True(t, ok, "line %q of %s has no timestamp", line, tc.file)
+			_, err := time.Parse(time.RFC3339Nano, timestamp)
+			assert.NoError(t, err, "line %q of %s has an invalid timestamp", line, tc.file)
+			lines = append(lines, logged)
+		}
+		assert.Equal(t, tc.expectedLines, lines, tc.file)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.ArtifactsDir, "index.json"))
+	require.NoError(t, err)
+	var index ArtifactsIndex
+	require.NoError(t, json.Unmarshal(data, &index))
+
+	containerID := func(name string) string {
+		c, ok := docker.containerNamed(name)
+		require.True(t, ok, name)
+		return c.id
+	}
+	assert.Equal(t, ArtifactsIndex{Services: []ServiceArtifacts{
+		{
+			Service:     "db",
+			Container:   "db",
+			ContainerID: containerID("db"),
+			Image:       "docker.io/library/db:latest",
+			Stdout:      "db.stdout.log",
+			Stderr:      "db.stderr.log",
+		},
+		{
+			Service:     "api",
+			Container:   "api",
+			ContainerID: containerID("api"),
+			Image:       "docker.io/library/api:latest",
+			Stdout:      "api.stdout.log",
+			Stderr:      "api.stderr.log",
+		},
+	}}, index)
+}
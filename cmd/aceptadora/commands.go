@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cabify/aceptadora"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// stopTimeout is the time given to the services to stop when `up` is interrupted
+const stopTimeout = time.Minute
+
+// up runs the services and their dependencies like RunAll does.
+// Unless detached, it keeps streaming their logs and serving the proxied ports until it's interrupted, and then stops them.
+func (c *cli) up(ctx context.Context, args []string) error {
+	flags := c.flagSet("up")
+	detach := flags.Bool("d", false, "exit once the services are ready, leaving them running")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	core, err := c.core()
+	if err != nil {
+		return err
+	}
+	if err := core.RunAll(ctx, flags.Args()...); err != nil {
+		return fmt.Errorf("%w\nThe services started are left running for inspection, use `down` to remove them", err)
+	}
+	if *detach {
+		c.log.Logf("Services are ready, use `down` to remove them")
+		return nil
+	}
+
+	c.log.Logf("Services are ready, interrupt to stop them")
+	<-ctx.Done()
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	return core.StopAll(stopCtx)
+}
+
+// down removes the containers and the networks created by aceptadora for the session
+func (c *cli) down(ctx context.Context, _ []string) error {
+	docker, err := dockerClient()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	containers, err := c.containers(ctx, docker)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, ctr := range containers {
+		err := docker.ContainerRemove(ctx, ctr.ID, container.RemoveOptions{RemoveVolumes: true, Force: true})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't remove container %s: %w", containerName(ctr), err))
+			continue
+		}
+		c.log.Logf("Removed container %s", containerName(ctr))
+	}
+
+	networks, err := docker.NetworkList(ctx, network.ListOptions{Filters: filters.NewArgs(c.sessionFilter())})
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("can't list networks: %w", err))...)
+	}
+	for _, nw := range networks {
+		if err := docker.NetworkRemove(ctx, nw.ID); err != nil {
+			errs = append(errs, fmt.Errorf("can't remove network %s: %w", nw.Name, err))
+			continue
+		}
+		c.log.Logf("Removed network %s", nw.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// ps lists the containers created by aceptadora for the session
+func (c *cli) ps(ctx context.Context, _ []string) error {
+	docker, err := dockerClient()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	containers, err := c.containers(ctx, docker)
+	if err != nil {
+		return err
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Labels[aceptadora.LabelService] < containers[j].Labels[aceptadora.LabelService]
+	})
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tCONTAINER\tID\tSTATUS\tPORTS")
+	for _, ctr := range containers {
+		fmt.Fprintf(w, "%s\t%s\t%.12s\t%s\t%s\n",
+			ctr.Labels[aceptadora.LabelService], containerName(ctr), ctr.ID, ctr.Status, formatPorts(ctr.Ports))
+	}
+	return w.Flush()
+}
+
+// logs prints the logs of the container of a service, following them if requested
+func (c *cli) logs(ctx context.Context, args []string) error {
+	flags := c.flagSet("logs")
+	follow := flags.Bool("f", false, "follow the logs until interrupted")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%w, usage: aceptadora logs [-f] <service>", errUsage)
+	}
+
+	docker, err := dockerClient()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	ctr, err := c.container(ctx, docker, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	out, err := docker.ContainerLogs(ctx, ctr.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     *follow,
+	})
+	if err != nil {
+		return fmt.Errorf("can't read the logs of %s: %w", containerName(ctr), err)
+	}
+	defer out.Close()
+
+	if _, err := stdcopy.StdCopy(c.stdout, c.stderr, out); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("can't read the logs of %s: %w", containerName(ctr), err)
+	}
+	return nil
+}
+
+// exec executes a command in the running container of a service like Exec does, exiting with the exit code of the command
+func (c *cli) exec(ctx context.Context, args []string) error {
+	flags := c.flagSet("exec")
+	stdin := flags.Bool("i", false, "write the standard input to the command")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return fmt.Errorf("%w, usage: aceptadora exec [-i] <service> <cmd> [args]", errUsage)
+	}
+
+	docker, err := dockerClient()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	ctr, err := c.container(ctx, docker, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg := aceptadora.ExecConfig{Cmd: flags.Args()[1:], Stdout: c.stdout, Stderr: c.stderr}
+	if *stdin {
+		cfg.Stdin = c.stdin
+	}
+	res, err := aceptadora.ExecInContainer(ctx, ctr.ID, cfg)
+	if err != nil {
+		return fmt.Errorf("can't exec %q in %s: %w", cfg.Cmd, containerName(ctr), err)
+	}
+	if res.ExitCode != 0 {
+		return exitCode(res.ExitCode)
+	}
+	return nil
+}
+
+// pull pulls the images of the services provided, or all of them, like PullImages does
+func (c *cli) pull(ctx context.Context, args []string) error {
+	core, err := c.core()
+	if err != nil {
+		return err
+	}
+	return core.PullImages(ctx, args...)
+}
+
+func dockerClient() (*client.Client, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a docker client: %w", err)
+	}
+	return docker, nil
+}
+
+// sessionFilter matches the resources labelled with the session, which is empty if no session is used
+func (c *cli) sessionFilter() filters.KeyValuePair {
+	return filters.Arg("label", aceptadora.LabelSession+"="+c.session)
+}
+
+// containers lists the containers of the services run by aceptadora for the session, including the stopped ones
+func (c *cli) containers(ctx context.Context, docker *client.Client, extra ...filters.KeyValuePair) ([]types.Container, error) {
+	args := append([]filters.KeyValuePair{c.sessionFilter(), filters.Arg("label", aceptadora.LabelService)}, extra...)
+	containers, err := docker.ContainerList(ctx, container.ListOptions{All: true, Filters: filters.NewArgs(args...)})
+	if err != nil {
+		return nil, fmt.Errorf("can't list containers: %w", err)
+	}
+	return containers, nil
+}
+
+// container returns the container of the service with the given name, which is the name it was run with
+func (c *cli) container(ctx context.Context, docker *client.Client, name string) (types.Container, error) {
+	containers, err := c.containers(ctx, docker, filters.Arg("label", aceptadora.LabelService+"="+name))
+	if err != nil {
+		return types.Container{}, err
+	}
+	if len(containers) == 0 {
+		return types.Container{}, fmt.Errorf("there's no container for service %q", name)
+	}
+	return containers[0], nil
+}
+
+func containerName(ctr types.Container) string {
+	if len(ctr.Names) == 0 {
+		return ctr.ID
+	}
+	return strings.TrimPrefix(ctr.Names[0], "/")
+}
+
+func formatPorts(ports []types.Port) string {
+	formatted := make([]string, 0, len(ports))
+	for _, p := range ports {
+		if p.PublicPort == 0 {
+			formatted = append(formatted, fmt.Sprintf("%d/%s", p.PrivatePort, p.Type))
+			continue
+		}
+		formatted = append(formatted, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+	}
+	sort.Strings(formatted)
+	return strings.Join(formatted, ", ")
+}
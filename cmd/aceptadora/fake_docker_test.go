@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// fakeDocker is a docker engine API serving the part of it used by the CLI and the Core,
+// which is reached through DOCKER_HOST like the docker CLI reaches the real one.
+type fakeDocker struct {
+	mtx        sync.Mutex
+	containers map[string]*fakeContainer
+	networks   map[string]*fakeNetwork
+	lastID     int
+	lastPort   int
+}
+
+type fakeContainer struct {
+	id      string
+	name    string
+	image   string
+	labels  map[string]string
+	ports   nat.PortMap
+	running bool
+	// attached are the streams of the attached clients, which are closed when the container is removed
+	attached []net.Conn
+}
+
+type fakeNetwork struct {
+	id     string
+	name   string
+	labels map[string]string
+}
+
+// newFakeDocker serves the fake docker engine until the test finishes, setting DOCKER_HOST to reach it
+func newFakeDocker(t *testing.T) *fakeDocker {
+	d := &fakeDocker{
+		containers: map[string]*fakeContainer{},
+		networks:   map[string]*fakeNetwork{},
+		lastPort:   32767,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /images/create", d.imageCreate)
+	mux.HandleFunc("GET /containers/json", d.containerList)
+	mux.HandleFunc("POST /containers/create", d.containerCreate)
+	mux.HandleFunc("GET /containers/{id}/json", d.containerInspect)
+	mux.HandleFunc("POST /containers/{id}/attach", d.containerAttach)
+	mux.HandleFunc("POST /containers/{id}/start", d.containerStart)
+	mux.HandleFunc("DELETE /containers/{id}", d.containerRemove)
+	mux.HandleFunc("GET /networks", d.networkList)
+	mux.HandleFunc("GET /networks/{id}", d.networkInspect)
+	mux.HandleFunc("POST /networks/create", d.networkCreate)
+	mux.HandleFunc("POST /networks/{id}/connect", d.networkConnect)
+	mux.HandleFunc("DELETE /networks/{id}", d.networkRemove)
+
+	// the client prefixes the paths with the API version
+	version := regexp.MustCompile(`^/v[0-9.]+/`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = version.ReplaceAllString(r.URL.Path, "/")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		d.mtx.Lock()
+		for _, c := range d.containers {
+			c.closeAttached()
+		}
+		d.mtx.Unlock()
+		srv.Close()
+	})
+
+	t.Setenv("DOCKER_HOST", "tcp://"+srv.Listener.Addr().String())
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+	t.Setenv("DOCKER_API_VERSION", "")
+	// there are no credentials to pull the images
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	return d
+}
+
+// containerNames returns the names of the containers, and whether they're running
+func (d *fakeDocker) containerNames() map[string]bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	names := map[string]bool{}
+	for _, c := range d.containers {
+		names[c.name] = c.running
+	}
+	return names
+}
+
+// networkNames returns the names of the networks
+func (d *fakeDocker) networkNames() []string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var names []string
+	for _, nw := range d.networks {
+		names = append(names, nw.name)
+	}
+	return names
+}
+
+func (d *fakeDocker) nextID() string {
+	d.lastID++
+	return fmt.Sprintf("%064x", d.lastID)
+}
+
+// container finds the container by ID or name, it should be called with the mutex held
+func (d *fakeDocker) container(w http.ResponseWriter, r *http.Request) (*fakeContainer, bool) {
+	id := r.PathValue("id")
+	for _, c := range d.containers {
+		if c.id == id || c.name == id {
+			return c, true
+		}
+	}
+	writeError(w, http.StatusNotFound, "No such container: %s", id)
+	return nil, false
+}
+
+// network finds the network by ID or name, it should be called with the mutex held
+func (d *fakeDocker) network(w http.ResponseWriter, r *http.Request) (*fakeNetwork, bool) {
+	id := r.PathValue("id")
+	for _, nw := range d.networks {
+		if nw.id == id || nw.name == id {
+			return nw, true
+		}
+	}
+	writeError(w, http.StatusNotFound, "network %s not found", id)
+	return nil, false
+}
+
+func (d *fakeDocker) imageCreate(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Pulled " + r.URL.Query().Get("fromImage")})
+}
+
+func (d *fakeDocker) containerList(w http.ResponseWriter, r *http.Request) {
+	args, ok := requestFilters(w, r)
+	if !ok {
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	list := []types.Container{}
+	for _, c := range d.containers {
+		if (args.Contains("name") && !args.Match("name", "/"+c.name)) || !args.MatchKVList("label", c.labels) {
+			continue
+		}
+		status := "Exited (0)"
+		if c.running {
+			status = "Up"
+		}
+		listed := types.Container{ID: c.id, Names: []string{"/" + c.name}, Image: c.image, Labels: c.labels, Status: status}
+		for port, bindings := range c.ports {
+			for _, b := range bindings {
+				public, _ := strconv.Atoi(b.HostPort)
+				listed.Ports = append(listed.Ports, types.Port{
+					IP: "0.0.0.0", PrivatePort: uint16(port.Int()), PublicPort: uint16(public), Type: port.Proto(),
+				})
+			}
+		}
+		list = append(list, listed)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (d *fakeDocker) containerCreate(w http.ResponseWriter, r *http.Request) {
+	var req container.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: %s", err)
+		return
+	}
+	name := r.URL.Query().Get("name")
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, c := range d.containers {
+		if c.name == name {
+			writeError(w, http.StatusConflict, "Conflict. The container name %q is already in use", name)
+			return
+		}
+	}
+	c := &fakeContainer{id: d.nextID(), name: name, image: req.Image, labels: req.Labels, ports: nat.PortMap{}}
+	if req.HostConfig != nil {
+		for port, bindings := range req.HostConfig.PortBindings {
+			for _, b := range bindings {
+				if b.HostPort == "" {
+					d.lastPort++
+					b.HostPort = strconv.Itoa(d.lastPort)
+				}
+				c.ports[port] = append(c.ports[port], b)
+			}
+		}
+	}
+	d.containers[c.id] = c
+	writeJSON(w, http.StatusCreated, container.CreateResponse{ID: c.id})
+}
+
+func (d *fakeDocker) containerInspect(w http.ResponseWriter, r *http.Request) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, ok := d.container(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: c.id, Name: "/" + c.name, State: &types.ContainerState{Running: c.running}},
+		Config:            &container.Config{Image: c.image, Labels: c.labels},
+		NetworkSettings:   &types.NetworkSettings{NetworkSettingsBase: types.NetworkSettingsBase{Ports: c.ports}},
+	})
+}
+
+// containerAttach hijacks the connection like the engine does, streaming the logs of the container into it
+func (d *fakeDocker) containerAttach(w http.ResponseWriter, r *http.Request) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, ok := d.container(w, r)
+	if !ok {
+		return
+	}
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "can't hijack: %s", err)
+		return
+	}
+	_, _ = buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	_ = buf.Flush()
+	c.attached = append(c.attached, conn)
+}
+
+func (d *fakeDocker) containerStart(w http.ResponseWriter, r *http.Request) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, ok := d.container(w, r)
+	if !ok {
+		return
+	}
+	c.running = true
+	for _, conn := range c.attached {
+		_, _ = stdcopy.NewStdWriter(conn, stdcopy.Stdout).Write([]byte(c.name + " started\n"))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *fakeDocker) containerRemove(w http.ResponseWriter, r *http.Request) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, ok := d.container(w, r)
+	if !ok {
+		return
+	}
+	c.closeAttached()
+	delete(d.containers, c.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *fakeContainer) closeAttached() {
+	for _, conn := range c.attached {
+		_ = conn.Close()
+	}
+	c.attached = nil
+}
+
+func (d *fakeDocker) networkList(w http.ResponseWriter, r *http.Request) {
+	args, ok := requestFilters(w, r)
+	if !ok {
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	list := []network.Summary{}
+	for _, nw := range d.networks {
+		if args.MatchKVList("label", nw.labels) {
+			list = append(list, network.Summary{ID: nw.id, Name: nw.name, Labels: nw.labels})
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (d *fakeDocker) networkInspect(w http.ResponseWriter, r *http.Request) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	nw, ok := d.network(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, network.Inspect{ID: nw.id, Name: nw.name, Labels: nw.labels})
+}
+
+func (d *fakeDocker) networkCreate(w http.ResponseWriter, r *http.Request) {
+	var req network.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: %s", err)
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, nw := range d.networks {
+		if nw.name == req.Name {
+			writeError(w, http.StatusConflict, "network with name %s already exists", req.Name)
+			return
+		}
+	}
+	nw := &fakeNetwork{id: d.nextID(), name: req.Name, labels: req.Labels}
+	d.networks[nw.id] = nw
+	writeJSON(w, http.StatusCreated, network.CreateResponse{ID: nw.id})
+}
+
+func (d *fakeDocker) networkConnect(w http.ResponseWriter, r *http.Request) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if _, ok := d.network(w, r); ok {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (d *fakeDocker) networkRemove(w http.ResponseWriter, r *http.Request) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	nw, ok := d.network(w, r)
+	if !ok {
+		return
+	}
+	delete(d.networks, nw.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func requestFilters(w http.ResponseWriter, r *http.Request) (filters.Args, bool) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid filters: %s", err)
+		return filters.Args{}, false
+	}
+	return args, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the error like the engine does, so the client recognizes it, like errdefs.IsNotFound
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"message": strings.TrimSpace(fmt.Sprintf(format, args...))})
+}
@@ -0,0 +1,180 @@
+// Command aceptadora runs the services defined in an aceptadora.yml outside of the tests,
+// with the same semantics as the library, so the dependencies of a suite can be brought up
+// while the test subject is run from the IDE.
+//
+// Usage:
+//
+//	aceptadora [flags] <command> [args]
+//
+// The commands are:
+//
+//	up [-d] [services]         runs the services (all if none provided) and their dependencies, waiting until they're ready
+//	down                       removes the containers and networks of the session
+//	ps                         lists the containers of the session
+//	logs [-f] <service>        prints the logs of a service
+//	exec [-i] <service> <cmd>  executes a command in a running service
+//	pull [services]            pulls the images of the services (all if none provided)
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/cabify/aceptadora"
+)
+
+// envFiles is a repeatable flag with the env files to load
+type envFiles []string
+
+func (e *envFiles) String() string { return strings.Join(*e, ",") }
+
+func (e *envFiles) Set(path string) error {
+	*e = append(*e, path)
+	return nil
+}
+
+// errUsage is returned when the arguments of a command are invalid, which exits with code 2 like the flag package does
+var errUsage = errors.New("invalid arguments")
+
+// exitCode is returned to exit with the given code, like exec does with the exit code of the command
+type exitCode int
+
+func (e exitCode) Error() string { return fmt.Sprintf("exit code %d", int(e)) }
+
+type cli struct {
+	log    aceptadora.Logger
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	yamlPath        string
+	envFiles        envFiles
+	session         string
+	servicesAddress string
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	c := &cli{
+		log:    aceptadora.LoggerFunc(log.New(os.Stderr, "", log.LstdFlags).Printf),
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	code := c.run(ctx, os.Args[1:])
+	cancel()
+	os.Exit(code)
+}
+
+// run parses the command line and runs the command, returning the exit code
+func (c *cli) run(ctx context.Context, args []string) int {
+	cmd, args, err := c.parse(args)
+	if err == nil {
+		err = c.command(ctx, cmd, args)
+	}
+
+	var code exitCode
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &code):
+		return int(code)
+	case errors.Is(err, errUsage):
+		c.log.Logf("%s", err)
+		return 2
+	default:
+		c.log.Logf("%s", err)
+		return 1
+	}
+}
+
+// parse parses the global flags, returning the command and its args
+func (c *cli) parse(args []string) (string, []string, error) {
+	flags := c.flagSet("aceptadora")
+	flags.StringVar(&c.yamlPath, "f", "aceptadora.yml", "path of the aceptadora.yml")
+	flags.Var(&c.envFiles, "env", "env file to load before loading the YAML, like SetEnv does (can be repeated)")
+	flags.StringVar(&c.session, "session", "", "session ID prefixing the names of the containers and networks, like Config.SessionID")
+	flags.StringVar(&c.servicesAddress, "services-address", "127.0.0.1", "address where the ports published by the services are reached")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: aceptadora [flags] <up|down|ps|logs|exec|pull> [args]\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return "", nil, err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return "", nil, fmt.Errorf("%w: no command provided", errUsage)
+	}
+	return flags.Arg(0), flags.Args()[1:], nil
+}
+
+// command runs the command with its args
+func (c *cli) command(ctx context.Context, cmd string, args []string) error {
+	switch cmd {
+	case "up":
+		return c.up(ctx, args)
+	case "down":
+		return c.down(ctx, args)
+	case "ps":
+		return c.ps(ctx, args)
+	case "logs":
+		return c.logs(ctx, args)
+	case "exec":
+		return c.exec(ctx, args)
+	case "pull":
+		return c.pull(ctx, args)
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, cmd)
+	}
+}
+
+// flagSet creates the flags of a command, which report their errors and usage on the cli's stderr
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// parseFlags parses the args, wrapping the invalid ones as errUsage, as the flag package has already reported them
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errUsage, err)
+}
+
+// core loads the env files and creates a Core like the tests create an Aceptadora
+func (c *cli) core() (*aceptadora.Core, error) {
+	matchers := make([]aceptadora.ConfigPathMatcher, 0, len(c.envFiles))
+	for _, path := range c.envFiles {
+		matchers = append(matchers, aceptadora.EnvConfigAlways(path))
+	}
+	if err := aceptadora.LoadEnv(c.log, matchers...); err != nil {
+		return nil, err
+	}
+
+	puller := aceptadora.NewCoreImagePuller(c.log, aceptadora.ImagePullerConfig{})
+	return aceptadora.NewCore(c.log, puller, aceptadora.Config{
+		YAMLDir:         filepath.Dir(c.yamlPath),
+		YAMLName:        filepath.Base(c.yamlPath),
+		SessionID:       c.session,
+		ServicesAddress: c.servicesAddress,
+		// there's no test failing, so the logs of the services are always shown, unless they define their own log_mode
+		LogMode: aceptadora.LogModeAlways,
+		// the reaper would remove the containers as soon as the CLI exits
+		Reaper: aceptadora.ReaperConfig{Enabled: false},
+	})
+}
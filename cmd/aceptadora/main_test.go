@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cabify/aceptadora"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCLI is a cli writing into buffers, keeping the lines it logs.
+// The lines aren't logged into the test, as the detached services keep logging after the command returns.
+type testCLI struct {
+	*cli
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+
+	mtx   sync.Mutex
+	lines []string
+}
+
+func newTestCLI() *testCLI {
+	c := &testCLI{}
+	c.cli = &cli{
+		log: aceptadora.LoggerFunc(func(format string, args ...any) {
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+			c.lines = append(c.lines, fmt.Sprintf(format, args...))
+		}),
+		stdin:  strings.NewReader(""),
+		stdout: &c.stdout,
+		stderr: &c.stderr,
+	}
+	return c
+}
+
+// logged returns the lines logged containing s
+func (c *testCLI) logged(s string) []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var found []string
+	for _, line := range c.lines {
+		if strings.Contains(line, s) {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+func TestCLI_Parse(t *testing.T) {
+	c := newTestCLI()
+	cmd, args, err := c.parse([]string{
+		"-f", "testdata/aceptadora.yml", "-env", "a.env", "-env", "b.env", "-session", "dev", "-services-address", "10.0.0.1",
+		"logs", "-f", "api",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "logs", cmd)
+	assert.Equal(t, []string{"-f", "api"}, args, "the flags after the command are the command's")
+	assert.Equal(t, "testdata/aceptadora.yml", c.yamlPath)
+	assert.Equal(t, envFiles{"a.env", "b.env"}, c.envFiles)
+	assert.Equal(t, "dev", c.session)
+	assert.Equal(t, "10.0.0.1", c.servicesAddress)
+
+	c = newTestCLI()
+	cmd, args, err = c.parse([]string{"ps"})
+	require.NoError(t, err)
+	assert.Equal(t, "ps", cmd)
+	assert.Empty(t, args)
+	assert.Equal(t, "aceptadora.yml", c.yamlPath)
+	assert.Empty(t, c.envFiles)
+	assert.Empty(t, c.session)
+	assert.Equal(t, "127.0.0.1", c.servicesAddress)
+}
+
+func TestCLI_InvalidArguments(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		args           []string
+		expectedCode   int
+		expectedStderr string
+		expectedLog    string
+	}{
+		{name: "no command", expectedCode: 2, expectedStderr: "Usage: aceptadora [flags] <up|down|ps|logs|exec|pull> [args]"},
+		{name: "help", args: []string{"-h"}, expectedCode: 0, expectedStderr: "Usage: aceptadora"},
+		{name: "unknown flag", args: []string{"-x", "ps"}, expectedCode: 2, expectedStderr: "flag provided but not defined: -x"},
+		{name: "unknown command", args: []string{"start"}, expectedCode: 2, expectedLog: `unknown command "start"`},
+		{name: "unknown flag of a command", args: []string{"up", "-x"}, expectedCode: 2, expectedStderr: "flag provided but not defined: -x"},
+		{name: "help of a command", args: []string{"up", "-h"}, expectedCode: 0, expectedStderr: "exit once the services are ready"},
+		{name: "logs without service", args: []string{"logs", "-f"}, expectedCode: 2, expectedLog: "usage: aceptadora logs [-f] <service>"},
+		{name: "logs of several services", args: []string{"logs", "api", "db"}, expectedCode: 2, expectedLog: "usage: aceptadora logs [-f] <service>"},
+		{name: "exec without command", args: []string{"exec", "api"}, expectedCode: 2, expectedLog: "usage: aceptadora exec [-i] <service> <cmd> [args]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestCLI()
+			assert.Equal(t, tc.expectedCode, c.run(context.Background(), tc.args))
+			assert.Contains(t, c.stderr.String(), tc.expectedStderr)
+			if tc.expectedLog != "" {
+				assert.Len(t, c.logged(tc.expectedLog), 1)
+			}
+			assert.Empty(t, c.stdout.String())
+		})
+	}
+}
+
+func TestCLI_UpPsDown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker(t)
+	yamlPath := filepath.Join(t.TempDir(), "aceptadora.yml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`services:
+  api:
+    image: docker.io/library/api:latest
+    ports:
+      - 80
+  db:
+    image: docker.io/library/db:latest
+`), 0o644))
+	t.Setenv("TESTER_ADDRESS", "127.0.0.1")
+
+	run := func(args ...string) *testCLI {
+		c := newTestCLI()
+		code := c.run(ctx, append([]string{"-f", yamlPath, "-session", "dev"}, args...))
+		require.Equal(t, 0, code, "%q failed: %s", args, c.logged(""))
+		return c
+	}
+
+	// the services of another session are left untouched
+	c := newTestCLI()
+	require.Equal(t, 0, c.run(ctx, []string{"-f", yamlPath, "-session", "other", "up", "-d", "db"}), "%s", c.logged(""))
+
+	up := run("up", "-d")
+	assert.Equal(t, map[string]bool{"dev-api": true, "dev-db": true, "other-db": true}, docker.containerNames())
+	assert.ElementsMatch(t, []string{"dev-acceptance-testing", "other-acceptance-testing"}, docker.networkNames())
+	assert.Len(t, up.logged("Services are ready, use `down` to remove them"), 1)
+
+	ps := run("ps")
+	lines := strings.Split(strings.TrimSuffix(ps.stdout.String(), "\n"), "\n")
+	require.Len(t, lines, 3, ps.stdout.String())
+	assert.Equal(t, []string{"SERVICE", "CONTAINER", "ID", "STATUS", "PORTS"}, strings.Fields(lines[0]))
+	api := strings.Fields(lines[1])
+	assert.Equal(t, []string{"api", "dev-api"}, api[:2])
+	assert.Len(t, api[2], 12, "the ID is truncated like docker ps does")
+	assert.Equal(t, []string{"Up", "0.0.0.0:32768->80/tcp"}, api[3:])
+	assert.Equal(t, []string{"db", "dev-db"}, strings.Fields(lines[2])[:2])
+
+	down := run("down")
+	assert.Equal(t, map[string]bool{"other-db": true}, docker.containerNames())
+	assert.Equal(t, []string{"other-acceptance-testing"}, docker.networkNames())
+	assert.Len(t, down.logged("Removed container dev-api"), 1)
+	assert.Len(t, down.logged("Removed container dev-db"), 1)
+	assert.Len(t, down.logged("Removed network dev-acceptance-testing"), 1)
+
+	// there's nothing left to list
+	ps = run("ps")
+	assert.Equal(t, "SERVICE  CONTAINER  ID  STATUS  PORTS\n", ps.stdout.String())
+}
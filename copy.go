@@ -0,0 +1,210 @@
+package aceptadora
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Copy defines a file or directory from the host to be copied into the container before starting it.
+// Unlike binds, this works when the docker daemon can't see the host's filesystem, like when using docker-in-docker.
+type Copy struct {
+	// Src is the path of the file or directory on the host
+	Src string `yaml:"src"`
+	// Dst is the path of the file or directory in the container, its parent directory should already exist
+	Dst string `yaml:"dst"`
+}
+
+// CopyTo copies the file or directory at hostPath on the host to containerPath in the container.
+// The parent directory of containerPath should already exist in the container.
+func (r *Runner) CopyTo(ctx context.Context, hostPath, containerPath string) {
+	err := r.copyTo(ctx, hostPath, containerPath)
+	r.require.NoError(err, "Can't copy %q to %q in %q: %s", hostPath, containerPath, r.name, err)
+}
+
+// CopyFrom copies the file or directory at containerPath in the container to hostPath on the host.
+func (r *Runner) CopyFrom(ctx context.Context, containerPath, hostPath string) {
+	err := r.copyFrom(ctx, containerPath, hostPath)
+	r.require.NoError(err, "Can't copy %q in %q to %q: %s", containerPath, r.name, hostPath, err)
+}
+
+// copyFiles copies the files defined in the service's `copy` section into the container
+func (r *Runner) copyFiles(ctx context.Context) error {
+	for _, c := range r.svc.Copy {
+		if err := r.copyTo(ctx, c.Src, c.Dst); err != nil {
+			return fmt.Errorf("can't copy %q to %q: %w", c.Src, c.Dst, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) copyTo(ctx context.Context, hostPath, containerPath string) error {
+	if _, err := os.Stat(hostPath); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, hostPath, path.Base(containerPath)))
+	}()
+	defer pr.Close()
+
+	return r.client.CopyToContainer(ctx, r.container.ID, path.Dir(containerPath), pr, container.CopyToContainerOptions{})
+}
+
+func (r *Runner) copyFrom(ctx context.Context, containerPath, hostPath string) error {
+	content, _, err := r.client.CopyFromContainer(ctx, r.container.ID, containerPath)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return extractTar(content, path.Base(containerPath), hostPath)
+}
+
+// writeTar writes the file or directory at src as a tar archive into w, naming its root as name.
+func writeTar(w io.Writer, src, name string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractTar extracts the tar archive read from r into dst, replacing its root named name with dst itself.
+func extractTar(r io.Reader, name, dst string) error {
+	dst = filepath.Clean(dst)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, ok := archivePath(hdr.Name, name)
+		if !ok {
+			return fmt.Errorf("invalid path %q in archive, it's not in %q", hdr.Name, name)
+		}
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		if !withinDir(dst, target) {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		if err := checkNoSymlinks(dst, target); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, target, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := filepath.FromSlash(hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || filepath.IsAbs(link) || !withinDir(dst, filepath.Join(filepath.Dir(target), link)) {
+				return fmt.Errorf("invalid symlink %q to %q in archive, it points outside of %q", hdr.Name, hdr.Linkname, dst)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// archivePath returns the path of the archive entry named entry relative to its root named name,
+// or false if the entry isn't the root or inside of it.
+func archivePath(entry, name string) (string, bool) {
+	entry = strings.TrimSuffix(entry, "/")
+	if entry == name {
+		return "", true
+	}
+	rel, ok := strings.CutPrefix(entry, name+"/")
+	return rel, ok
+}
+
+// withinDir returns true if target is dir or it's inside of it, both being clean paths
+func withinDir(dir, target string) bool {
+	return target == dir || strings.HasPrefix(target, dir+string(filepath.Separator))
+}
+
+// checkNoSymlinks returns an error if target, or any of its parents inside of dst, is an existing symlink,
+// as the archive could write through it outside of dst.
+func checkNoSymlinks(dst, target string) error {
+	for p := target; p != dst && withinDir(dst, p); p = filepath.Dir(p) {
+		info, err := os.Lstat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("can't extract %q through the symlink %q", target, p)
+		}
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
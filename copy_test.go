@@ -0,0 +1,167 @@
+package aceptadora
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTar_RoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes and symlinks aren't preserved on windows")
+	}
+
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "config.yml"), []byte("port: 80\n"), 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(src, "bin"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "bin", "run.sh"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.Symlink("bin/run.sh", filepath.Join(src, "run")))
+
+	var archive bytes.Buffer
+	require.NoError(t, writeTar(&archive, src, "app"))
+	dst := filepath.Join(t.TempDir(), "copied")
+	require.NoError(t, extractTar(&archive, "app", dst))
+
+	for _, tc := range []struct {
+		path    string
+		content string
+	}{
+		{path: "config.yml", content: "port: 80\n"},
+		{path: "bin"},
+		{path: "bin/run.sh", content: "#!/bin/sh\n"},
+	} {
+		// the modes are compared with the source ones, as both are affected by the umask
+		srcInfo, err := os.Stat(filepath.Join(src, tc.path))
+		require.NoError(t, err, tc.path)
+		info, err := os.Stat(filepath.Join(dst, tc.path))
+		require.NoError(t, err, tc.path)
+		assert.Equal(t, srcInfo.Mode(), info.Mode(), tc.path)
+		if tc.content != "" {
+			content, err := os.ReadFile(filepath.Join(dst, tc.path))
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, string(content), tc.path)
+		}
+	}
+
+	link, err := os.Readlink(filepath.Join(dst, "run"))
+	require.NoError(t, err)
+	assert.Equal(t, "bin/run.sh", link)
+}
+
+func TestTar_RoundTripOfAFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(src, []byte("port: 80\n"), 0o644))
+
+	var archive bytes.Buffer
+	require.NoError(t, writeTar(&archive, src, "app.yml"))
+	dst := filepath.Join(t.TempDir(), "copied.yml")
+	require.NoError(t, extractTar(&archive, "app.yml", dst))
+
+	content, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "port: 80\n", string(content))
+}
+
+func TestExtractTar_RejectsMaliciousArchives(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the archives use symlinks")
+	}
+
+	for _, tc := range []struct {
+		name string
+		// entries are written in order: the ones with a Linkname are symlinks, the ones with TypeDir are dirs, and the rest are files
+		entries []tar.Header
+		// existingLink, if not empty, is created in the destination before extracting, pointing outside of it
+		existingLink string
+		expectedErr  string
+	}{
+		{
+			name:        "path escaping the destination",
+			entries:     []tar.Header{{Name: "app/../../evil"}},
+			expectedErr: `invalid path "app/../../evil" in archive`,
+		},
+		{
+			name:        "path sharing a prefix with the root",
+			entries:     []tar.Header{{Name: "application/evil"}},
+			expectedErr: `invalid path "application/evil" in archive, it's not in "app"`,
+		},
+		{
+			name:        "absolute symlink",
+			entries:     []tar.Header{{Name: "app/", Typeflag: tar.TypeDir}, {Name: "app/passwd", Linkname: "/etc/passwd"}},
+			expectedErr: `invalid symlink "app/passwd" to "/etc/passwd" in archive`,
+		},
+		{
+			name:        "relative symlink escaping the destination",
+			entries:     []tar.Header{{Name: "app/", Typeflag: tar.TypeDir}, {Name: "app/outside", Linkname: "../outside"}},
+			expectedErr: `invalid symlink "app/outside" to "../outside" in archive`,
+		},
+		{
+			name: "file written through a symlink of the archive",
+			entries: []tar.Header{
+				{Name: "app/", Typeflag: tar.TypeDir},
+				{Name: "app/sub/", Typeflag: tar.TypeDir},
+				{Name: "app/link", Linkname: "sub"},
+				{Name: "app/link/evil"},
+			},
+			expectedErr: "through the symlink",
+		},
+		{
+			name:         "file written through an existing symlink",
+			entries:      []tar.Header{{Name: "app/", Typeflag: tar.TypeDir}, {Name: "app/link/evil"}},
+			existingLink: "link",
+			expectedErr:  "through the symlink",
+		},
+		{
+			name:         "existing symlink overwritten by a file",
+			entries:      []tar.Header{{Name: "app/", Typeflag: tar.TypeDir}, {Name: "app/link"}},
+			existingLink: "link",
+			expectedErr:  "through the symlink",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var archive bytes.Buffer
+			tw := tar.NewWriter(&archive)
+			for _, hdr := range tc.entries {
+				hdr.Mode = 0o755
+				switch {
+				case hdr.Linkname != "":
+					hdr.Typeflag = tar.TypeSymlink
+				case hdr.Typeflag != tar.TypeDir:
+					hdr.Typeflag = tar.TypeReg
+					hdr.Size = int64(len("evil"))
+				}
+				require.NoError(t, tw.WriteHeader(&hdr))
+				if hdr.Typeflag == tar.TypeReg {
+					_, err := tw.Write([]byte("evil"))
+					require.NoError(t, err)
+				}
+			}
+			require.NoError(t, tw.Close())
+
+			root := t.TempDir()
+			dst := filepath.Join(root, "dst", "app")
+			outside := filepath.Join(root, "outside")
+			require.NoError(t, os.MkdirAll(dst, 0o755))
+			require.NoError(t, os.Mkdir(outside, 0o755))
+			if tc.existingLink != "" {
+				require.NoError(t, os.Symlink(outside, filepath.Join(dst, tc.existingLink)))
+			}
+
+			err := extractTar(&archive, "app", dst)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+
+			written, err := os.ReadDir(outside)
+			require.NoError(t, err)
+			assert.Empty(t, written, "nothing should be written outside of the destination")
+			_, err = os.Lstat(filepath.Join(root, "evil"))
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
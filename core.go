@@ -0,0 +1,585 @@
+package aceptadora
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+const (
+	// defaultYAMLDir and defaultYAMLName are used when Config doesn't provide them, like when it's not loaded by envconfig
+	defaultYAMLDir  = "./"
+	defaultYAMLName = "aceptadora.yml"
+
+	// defaultPullConcurrency is used by PullImages when Config.PullConcurrency is not provided
+	defaultPullConcurrency = 4
+)
+
+// Errors returned by Core when the service provided can't be used
+var (
+	// ErrServiceNotFound is returned when there's no such service in aceptadora.yml, or it was never run
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrServiceNotRunning is returned when the service is not running
+	ErrServiceNotRunning = errors.New("service not running")
+
+	// errAlreadyRunning is returned when running a service that is already running
+	errAlreadyRunning = errors.New("already running")
+)
+
+// Logger is where aceptadora writes what it does and the logs of the services, testing.TB implements it.
+// If it also implements `Failed() bool`, like testing.TB does, it's used to find out whether the test has failed,
+// for Config.KeepOnFailure and LogModeOnFailure.
+type Logger interface {
+	Logf(format string, args ...any)
+}
+
+// LoggerFunc adapts a function like log.Printf to a Logger
+type LoggerFunc func(format string, args ...any)
+
+// Logf calls f
+func (f LoggerFunc) Logf(format string, args ...any) {
+	f(format, args...)
+}
+
+// failed returns true if the logger reports that the test has failed
+func failed(log Logger) bool {
+	f, ok := log.(interface{ Failed() bool })
+	return ok && f.Failed()
+}
+
+// Core is the layer of aceptadora whose methods return errors instead of failing the test.
+// It doesn't need a testing.TB, so it can be used from TestMain to start the services shared by all the tests of a package,
+// or from any other tool.
+// Aceptadora is a thin wrapper around it, failing the test when something goes wrong.
+// All its methods are safe for concurrent use: each service is started and stopped holding its own lock,
+// so different services can be started in parallel.
+type Core struct {
+	cfg Config
+	log Logger
+
+	yaml     YAML
+	yamlPath string
+
+	imagePuller CoreImagePuller
+	// newClient creates the docker client of each runner
+	newClient func() (dockerClient, error)
+
+	mtx      sync.Mutex
+	services map[string]*Runner
+	order    []string
+	// locks are the locks held while starting or stopping each service
+	locks map[string]*sync.Mutex
+
+	// proxies are the proxies started for the `proxied_ports` of the services, and the ones started by ProxyTester
+	proxies map[string]*Proxy
+
+	// runID identifies the resources created by this Core
+	runID      string
+	reaperOnce sync.Once
+	reaper     net.Conn
+	reaperErr  error
+
+	// partitionMtx is held by Partition and Heal, so they don't move the same services concurrently
+	partitionMtx sync.Mutex
+	// partitioned maps the services isolated by Partition to the network of their group
+	partitioned map[string]string
+	partitions  int
+	// partitionNetworks are the networks of the groups of the partitions, with the runner whose client creates them.
+	// They're recorded before connecting any service, so they're removed by Heal even if the connection fails.
+	partitionNetworks map[string]*Runner
+
+	// keptLogged is true once the services kept running on failure have been logged
+	keptLogged bool
+}
+
+// NewCore creates a new Core, loading the YAML config from the path provided by Config.
+// Everything it does, and the logs of the services, are logged into the provided logger.
+// The fields of Config that aren't provided take the same defaults as when it's loaded by envconfig.
+func NewCore(log Logger, imagePuller CoreImagePuller, cfg Config) (*Core, error) {
+	if cfg.YAMLDir == "" {
+		cfg.YAMLDir = defaultYAMLDir
+	}
+	if cfg.YAMLName == "" {
+		cfg.YAMLName = defaultYAMLName
+	}
+
+	if _, ok := os.LookupEnv("TESTER_ADDRESS"); !ok {
+		os.Setenv("TESTER_ADDRESS", getLocalIP())
+	}
+
+	os.Setenv("YAMLDIR", cfg.YAMLDir)
+	yamlPath := cfg.YAMLDir + "/" + cfg.YAMLName
+	yaml, err := LoadYAML(yamlPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't load YAML from %q: %w", yamlPath, err)
+	}
+	if err := cfg.LogMode.validate(); err != nil {
+		return nil, fmt.Errorf("invalid Config.LogMode: %w", err)
+	}
+
+	return &Core{
+		cfg:         cfg,
+		log:         log,
+		yaml:        yaml,
+		yamlPath:    yamlPath,
+		runID:       newRunID(),
+		imagePuller: imagePuller,
+		newClient:   newDockerClient,
+		services:    map[string]*Runner{},
+		locks:       map[string]*sync.Mutex{},
+		partitioned: map[string]string{},
+		proxies:     map[string]*Proxy{},
+
+		partitionNetworks: map[string]*Runner{},
+	}, nil
+}
+
+// PullImages pulls the images of the services provided, or all the images mentioned in aceptadora.yml if none is provided.
+// Up to Config.PullConcurrency images are pulled concurrently, and the errors of all the images that can't be pulled are joined.
+func (c *Core) PullImages(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		names = c.yaml.serviceNames()
+	}
+	var pulls []imagePull
+	for _, name := range names {
+		svc, ok := c.yaml.Services[name]
+		if !ok {
+			return fmt.Errorf("%w: there's no service with name %q", ErrServiceNotFound, name)
+		}
+		if pull := (imagePull{svc.Image, svc.PullPolicy}); !slices.Contains(pulls, pull) {
+			pulls = append(pulls, pull)
+		}
+	}
+	sort.Slice(pulls, func(i, j int) bool {
+		return pulls[i].image < pulls[j].image || pulls[i].image == pulls[j].image && pulls[i].policy < pulls[j].policy
+	})
+
+	concurrency := c.cfg.PullConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultPullConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	errs := make([]error, len(pulls))
+	var wg sync.WaitGroup
+	for i, pull := range pulls {
+		wg.Add(1)
+		go func(i int, pull imagePull) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				errs[i] = fmt.Errorf("can't pull image %q: %w", pull.image, ctx.Err())
+				return
+			}
+			// the ImagePuller pulls each image only once, even if it's requested again by another call
+			if err := pullImage(ctx, c.imagePuller, pull.image, pull.policy); err != nil {
+				errs[i] = fmt.Errorf("can't pull image %q: %w", pull.image, err)
+			}
+		}(i, pull)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// imagePull is an image to be pulled with the pull policy of its service
+type imagePull struct {
+	image  string
+	policy PullPolicy
+}
+
+// Run starts a given service (from aceptadora.yml), waits until it's ready and registers it for stopping later.
+// See Aceptadora.Run.
+func (c *Core) Run(ctx context.Context, name string, opts ...RunOption) error {
+	return c.run(ctx, nil, name, opts...)
+}
+
+// run runs the service on behalf of the scope, if any
+func (c *Core) run(ctx context.Context, s *scope, name string, opts ...RunOption) error {
+	svc, ok := c.yaml.Services[name]
+	if !ok {
+		return fmt.Errorf("%w: there's no service with name %q", ErrServiceNotFound, name)
+	}
+	for _, opt := range opts {
+		opt(&svc)
+	}
+	instance := svc.instanceName(name)
+	unlock := c.lockService(instance)
+	defer unlock()
+	if runner, _ := c.runner(instance); runner.isRunning() {
+		return fmt.Errorf("service %q is %w", instance, errAlreadyRunning)
+	}
+
+	if err := c.startReaper(ctx); err != nil {
+		return fmt.Errorf("can't start the reaper: %w", err)
+	}
+
+	runner := newRunner(s.logger(c.log), instance, svc, c.imagePuller)
+	runner.newClient = c.newClient
+	if c.cfg.ServicesAddress != "" {
+		runner.servicesAddress = c.cfg.ServicesAddress
+	}
+	runner.session = c.cfg.SessionID
+	runner.yamlPath = c.yamlPath
+	runner.run = c.runID
+	runner.artifactsDir = c.cfg.ArtifactsDir
+	runner.defaultLogMode = c.cfg.LogMode
+	runner.defaultLogBufferSize = c.cfg.LogBufferSize
+	runner.defaultLogHistorySize = c.cfg.LogHistorySize
+	runner.logColors = c.cfg.LogColors
+	// the runner is registered even if it can't be started, so its container is stopped by StopAll
+	startErr := runner.start(ctx)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.services[instance] = runner
+	s.add(instance)
+	// if it was run before, it's moved to the end as it should be stopped before the services it might depend on
+	c.order = append(slices.DeleteFunc(c.order, func(s string) bool { return s == instance }), instance)
+	if err := c.writeArtifactsIndex(); err != nil {
+		return fmt.Errorf("can't write the artifacts index into %q: %w", c.cfg.ArtifactsDir, err)
+	}
+	if startErr != nil {
+		return fmt.Errorf("can't start %q: %w", instance, startErr)
+	}
+	return c.startProxies(instance, svc)
+}
+
+// RunAll starts the services provided (or all the services from aceptadora.yml if none is provided)
+// together with all their transitive dependencies declared in `depends_on`.
+// See Aceptadora.RunAll.
+func (c *Core) RunAll(ctx context.Context, names ...string) error {
+	return c.runAll(ctx, nil, names)
+}
+
+// runAll runs the services and their dependencies on behalf of the scope, if any
+func (c *Core) runAll(ctx context.Context, s *scope, names []string) error {
+	if len(names) == 0 {
+		names = c.yaml.serviceNames()
+	}
+	order, err := c.yaml.resolveDependencies(names)
+	if err != nil {
+		return fmt.Errorf("can't resolve dependencies of %v: %w", names, err)
+	}
+
+	results := make(map[string]*runResult, len(order))
+	for _, name := range order {
+		results[name] = &runResult{done: make(chan struct{}), err: fmt.Errorf("%q didn't start", name)}
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range order {
+		wg.Add(1)
+		go func(name string, res *runResult) {
+			defer wg.Done()
+			// a failing ImagePuller might call runtime.Goexit() on this goroutine, so done is closed in a defer
+			defer close(res.done)
+			res.err = c.runWithDependencies(ctx, s, name, results)
+		}(name, results[name])
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, name := range order {
+		if err := results[name].err; err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("can't start services: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+type runResult struct {
+	done chan struct{}
+	err  error
+}
+
+// runWithDependencies runs the service once its dependencies have reached their conditions, unless it's already running
+func (c *Core) runWithDependencies(ctx context.Context, s *scope, name string, results map[string]*runResult) error {
+	deps := c.yaml.Services[name].DependsOn
+	for _, dep := range deps.names() {
+		<-results[dep].done
+		if results[dep].err != nil {
+			return fmt.Errorf("its dependency %q couldn't be started", dep)
+		}
+		if err := c.waitForCondition(ctx, c.yaml.Services[dep].instanceName(dep), deps[dep].Condition); err != nil {
+			return fmt.Errorf("its dependency %q didn't reach the condition %s: %w", dep, deps[dep].Condition, err)
+		}
+	}
+
+	// it might have been started meanwhile, by another RunAll for instance
+	if err := c.run(ctx, s, name); err != nil && !errors.Is(err, errAlreadyRunning) {
+		return err
+	}
+	return nil
+}
+
+// waitForCondition waits until the running service reaches the provided condition
+func (c *Core) waitForCondition(ctx context.Context, name string, condition DependencyCondition) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+
+	switch condition {
+	case DependencyConditionHealthy:
+		return runner.WaitHealthy(ctx)
+	case DependencyConditionCompletedSuccessfully:
+		return runner.WaitCompleted(ctx)
+	default:
+		return nil
+	}
+}
+
+// Endpoint returns the address where the tester can reach the given container port of a running service.
+func (c *Core) Endpoint(name, port string) (string, error) {
+	runner, err := c.running(name)
+	if err != nil {
+		return "", err
+	}
+	return runner.endpoint(port)
+}
+
+// Exec executes the provided command inside of the container of a running service and waits until it finishes.
+// A non-zero exit code is not considered an error, it's up to the caller to check it.
+func (c *Core) Exec(ctx context.Context, name string, cmd ...string) (ExecResult, error) {
+	return c.ExecWithConfig(ctx, name, ExecConfig{Cmd: cmd})
+}
+
+// ExecWithConfig executes the command defined by the provided config inside of the container of a running service.
+func (c *Core) ExecWithConfig(ctx context.Context, name string, cfg ExecConfig) (ExecResult, error) {
+	runner, err := c.running(name)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	return runner.exec(ctx, cfg)
+}
+
+// CopyTo copies the file or directory at hostPath to containerPath in the container of a running service.
+func (c *Core) CopyTo(ctx context.Context, name, hostPath, containerPath string) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+	return runner.copyTo(ctx, hostPath, containerPath)
+}
+
+// CopyFrom copies the file or directory at containerPath in the container of a running service to hostPath.
+func (c *Core) CopyFrom(ctx context.Context, name, containerPath, hostPath string) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+	return runner.copyFrom(ctx, containerPath, hostPath)
+}
+
+// WaitForLog waits until the service logs a line matching the regexp pattern on any of the provided streams,
+// or on any stream if none is provided, and returns that line.
+func (c *Core) WaitForLog(ctx context.Context, name, pattern string, streams ...LogStream) (string, error) {
+	match, err := logMatcher(pattern, streams)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	e, err := c.WaitForLogEntry(ctx, name, match)
+	return e.Line, err
+}
+
+// WaitForLogEntry waits until the service logs an entry for which match returns true, and returns that entry.
+func (c *Core) WaitForLogEntry(ctx context.Context, name string, match func(LogEntry) bool) (LogEntry, error) {
+	runner, ok := c.runner(name)
+	if !ok {
+		return LogEntry{}, fmt.Errorf("%w: there's no service %q", ErrServiceNotFound, name)
+	}
+	return runner.logHistory.waitFor(ctx, match)
+}
+
+// LogEntries returns all the entries logged by the service for which match returns true, or all of them if match is nil.
+func (c *Core) LogEntries(name string, match func(LogEntry) bool) ([]LogEntry, error) {
+	runner, ok := c.runner(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: there's no service %q", ErrServiceNotFound, name)
+	}
+	return runner.LogEntries(match), nil
+}
+
+// StopAll stops all the services in the reverse order, and then closes all the proxies.
+// If the test failed and Config.KeepOnFailure is enabled, the services are left running instead,
+// and a summary of how to inspect them is logged.
+func (c *Core) StopAll(ctx context.Context) error {
+	if failed(c.log) && c.keepOnFailure() {
+		c.logKeptServices()
+		return c.closeProxies()
+	}
+
+	c.mtx.Lock()
+	order := slices.Clone(c.order)
+	c.mtx.Unlock()
+
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		errs = append(errs, c.Stop(ctx, order[i]))
+	}
+	errs = append(errs, c.closeProxies())
+	return errors.Join(errs...)
+}
+
+// stopScope stops the services run on behalf of the scope in the reverse order
+func (c *Core) stopScope(ctx context.Context, s *scope) error {
+	names := s.services()
+	var errs []error
+	for i := len(names) - 1; i >= 0; i-- {
+		errs = append(errs, c.Stop(ctx, names[i]))
+	}
+	return errors.Join(errs...)
+}
+
+// Stop stops the service with the name provided, unless it's already stopped.
+func (c *Core) Stop(ctx context.Context, name string) error {
+	unlock := c.lockService(name)
+	defer unlock()
+	runner, ok := c.runner(name)
+	if !ok {
+		return fmt.Errorf("%w: there's no service %q to stop", ErrServiceNotFound, name)
+	}
+	if err := runner.StopWithTimeout(ctx, c.cfg.StopTimeout); err != nil {
+		return fmt.Errorf("can't stop service %q in time: %w", name, err)
+	}
+	return nil
+}
+
+// Restart stops the service with the name provided (unless it's already stopped) and starts the same container again,
+// waiting until it's ready.
+func (c *Core) Restart(ctx context.Context, name string) error {
+	unlock := c.lockService(name)
+	defer unlock()
+	runner, ok := c.runner(name)
+	if !ok {
+		return fmt.Errorf("%w: there's no service %q to restart", ErrServiceNotFound, name)
+	}
+	if err := runner.StopWithTimeout(ctx, c.cfg.StopTimeout); err != nil {
+		return fmt.Errorf("can't stop service %q: %w", name, err)
+	}
+	return runner.start(ctx)
+}
+
+// Pause suspends all the processes of a running service.
+func (c *Core) Pause(ctx context.Context, name string) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+	return runner.pause(ctx)
+}
+
+// Unpause resumes all the processes of a paused service.
+func (c *Core) Unpause(ctx context.Context, name string) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+	return runner.unpause(ctx)
+}
+
+// Kill sends the provided signal, like "SIGTERM" or "SIGHUP", to a running service.
+// If the signal is empty, "SIGKILL" is sent.
+func (c *Core) Kill(ctx context.Context, name, signal string) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+	return runner.kill(ctx, signal)
+}
+
+// flushLogBuffers sends the buffered logs of the services to the logger if the test has failed
+func (c *Core) flushLogBuffers() {
+	c.mtx.Lock()
+	runners := make([]*Runner, 0, len(c.order))
+	for _, name := range c.order {
+		runners = append(runners, c.services[name])
+	}
+	c.mtx.Unlock()
+
+	for _, runner := range runners {
+		runner.flushLogBuffer()
+	}
+}
+
+// lockService acquires the lock of the service with the given name, returning the function that releases it
+func (c *Core) lockService(name string) (unlock func()) {
+	c.mtx.Lock()
+	lock, ok := c.locks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[name] = lock
+	}
+	c.mtx.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// running returns the runner of the service with the given name, or an error if it's not running
+func (c *Core) running(name string) (*Runner, error) {
+	runner, ok := c.runner(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: there's no service %q", ErrServiceNotFound, name)
+	}
+	if !runner.isRunning() {
+		return nil, fmt.Errorf("%w: service %q is not running", ErrServiceNotRunning, name)
+	}
+	return runner, nil
+}
+
+// runner returns the runner registered for the service with the given name.
+// It returns true if the service was registered, even if it was stopped.
+func (c *Core) runner(name string) (*Runner, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	runner, ok := c.services[name]
+	return runner, ok
+}
+
+// scope tracks the services run on behalf of a test, so they can be stopped once it finishes,
+// and the logs of those services are written into its logger.
+// A nil scope tracks nothing, and the logs are written into the logger of the Core.
+type scope struct {
+	log Logger
+
+	mtx   sync.Mutex
+	names []string
+}
+
+// logger returns the logger of the scope, or the provided one if the scope is nil
+func (s *scope) logger(fallback Logger) Logger {
+	if s == nil {
+		return fallback
+	}
+	return s.log
+}
+
+// add tracks the service, moving it to the end if it was already tracked, like Core does
+func (s *scope) add(name string) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.names = append(slices.DeleteFunc(s.names, func(n string) bool { return n == name }), name)
+}
+
+// services returns the services tracked, in the order they were run
+func (s *scope) services() []string {
+	if s == nil {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return slices.Clone(s.names)
+}
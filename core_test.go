@@ -0,0 +1,280 @@
+package aceptadora
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServices are the services defined in the aceptadora.yml used by the tests, all of them publishing the port 80
+var testServices = []string{"api", "cache", "db", "queue", "worker"}
+
+// newTestConfig writes an aceptadora.yml with the testServices, and returns the Config to load it
+func newTestConfig(t *testing.T) Config {
+	return newTestConfigWithDependencies(t, nil)
+}
+
+// newTestConfigWithDependencies is like newTestConfig, but the services depend on the ones provided by dependencies
+func newTestConfigWithDependencies(t *testing.T, dependencies map[string][]string) Config {
+	var yaml strings.Builder
+	yaml.WriteString("services:\n")
+	for _, name := range testServices {
+		fmt.Fprintf(&yaml, "  %s:\n    image: docker.io/library/%s:latest\n    ports:\n      - 80\n", name, name)
+		if deps := dependencies[name]; len(deps) > 0 {
+			fmt.Fprintf(&yaml, "    depends_on: [%s]\n", strings.Join(deps, ", "))
+		}
+	}
+	return newTestConfigWithYAML(t, yaml.String())
+}
+
+// newTestConfigWithYAML is like newTestConfig, but with the services defined in the yaml provided
+func newTestConfigWithYAML(t *testing.T, yaml string) Config {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "aceptadora.yml"), []byte(yaml), 0o644))
+
+	t.Setenv("TESTER_ADDRESS", "127.0.0.1")
+	return Config{YAMLDir: dir, YAMLName: "aceptadora.yml", ServicesAddress: "127.0.0.1"}
+}
+
+// newTestCore creates a Core running its services on the fake docker
+func newTestCore(t *testing.T, docker *fakeDocker) *Core {
+	core, err := NewCore(t, newFakePuller(), newTestConfig(t))
+	require.NoError(t, err)
+	core.newClient = docker.client
+	return core
+}
+
+func TestNewCore_DefaultsConfigBuiltInCode(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	// only the dir is provided, like in the TestMain example of the README
+	core, err := NewCore(t, newFakePuller(), Config{YAMLDir: cfg.YAMLDir})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cfg.YAMLDir, "aceptadora.yml"), filepath.Clean(core.yamlPath))
+
+	docker := newFakeDocker()
+	core.newClient = docker.client
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, core.Run(ctx, "api"))
+	endpoint, err := core.Endpoint("api", "80")
+	require.NoError(t, err)
+	assert.Regexp(t, `^127\.0\.0\.1:\d+$`, endpoint)
+	require.NoError(t, core.StopAll(ctx))
+}
+
+func TestCore_RunFailsIfTheExistingContainersCantBeListed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	docker.listErr = errors.New("daemon unavailable")
+	core := newTestCore(t, docker)
+
+	err := core.Run(ctx, "api")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `can't list the existing containers named "api": daemon unavailable`)
+	assert.Zero(t, docker.created())
+}
+
+func TestCore_ConcurrentRunStopAndLookup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	core := newTestCore(t, docker)
+
+	var wg sync.WaitGroup
+	for _, name := range testServices {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if !assert.NoError(t, core.Run(ctx, name)) {
+				return
+			}
+			_, err := core.WaitForLog(ctx, name, name+" started")
+			assert.NoError(t, err)
+
+			// look up the other services while they're being started and stopped
+			for _, other := range testServices {
+				if _, err := core.Endpoint(other, "80"); err != nil {
+					assert.True(t, errors.Is(err, ErrServiceNotFound) || errors.Is(err, ErrServiceNotRunning), "unexpected error: %s", err)
+				}
+				_, _ = core.LogEntries(other, nil)
+			}
+
+			assert.NoError(t, core.Pause(ctx, name))
+			assert.NoError(t, core.Unpause(ctx, name))
+			assert.NoError(t, core.Restart(ctx, name))
+			assert.NoError(t, core.Stop(ctx, name))
+			assert.NoError(t, core.Run(ctx, name))
+		}(name)
+	}
+	wg.Wait()
+
+	assert.Len(t, docker.running(), len(testServices))
+	require.NoError(t, core.StopAll(ctx))
+	assert.Empty(t, docker.running())
+}
+
+func TestCore_RunsDifferentServicesInParallel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// each container is started only once all of them are being started, so starting them serially would time out
+	var starting sync.WaitGroup
+	starting.Add(len(testServices))
+	allStarting := make(chan struct{})
+	go func() {
+		starting.Wait()
+		close(allStarting)
+	}()
+
+	docker := newFakeDocker()
+	docker.onStart = func(string) {
+		starting.Done()
+		select {
+		case <-allStarting:
+		case <-ctx.Done():
+		}
+	}
+	core := newTestCore(t, docker)
+
+	var wg sync.WaitGroup
+	for _, name := range testServices {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			assert.NoError(t, core.Run(ctx, name))
+		}(name)
+	}
+	wg.Wait()
+
+	require.NoError(t, ctx.Err(), "services were not started in parallel")
+	require.NoError(t, core.StopAll(ctx))
+}
+
+func TestCore_RunsTheSameServiceOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	core := newTestCore(t, docker)
+
+	const attempts = 10
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- core.Run(ctx, "api")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var started int
+	for err := range errs {
+		if err == nil {
+			started++
+			continue
+		}
+		assert.True(t, errors.Is(err, errAlreadyRunning), "unexpected error: %s", err)
+	}
+	assert.Equal(t, 1, started)
+	assert.Equal(t, 1, docker.created())
+
+	require.NoError(t, core.StopAll(ctx))
+}
+
+func TestCore_ConcurrentRunAll(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	core := newTestCore(t, docker)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, core.RunAll(ctx))
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, docker.running(), len(testServices))
+	assert.Equal(t, len(testServices), docker.created())
+	require.NoError(t, core.StopAll(ctx))
+}
+
+func TestCore_PullImagesConcurrently(t *testing.T) {
+	puller := newFakePuller()
+	puller.delay = 50 * time.Millisecond
+	puller.fail = map[string]bool{"docker.io/library/cache:latest": true, "docker.io/library/queue:latest": true}
+
+	cfg := newTestConfig(t)
+	cfg.PullConcurrency = 2
+	core, err := NewCore(t, puller, cfg)
+	require.NoError(t, err)
+
+	err = core.PullImages(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `can't pull image "docker.io/library/cache:latest"`)
+	assert.Contains(t, err.Error(), `can't pull image "docker.io/library/queue:latest"`)
+
+	assert.Len(t, puller.pulled, len(testServices))
+	assert.Equal(t, 2, puller.maxInFlight)
+}
+
+func TestCore_PullImagesOfSomeServices(t *testing.T) {
+	puller := newFakePuller()
+	core, err := NewCore(t, puller, newTestConfig(t))
+	require.NoError(t, err)
+
+	require.NoError(t, core.PullImages(context.Background(), "api", "db", "api"))
+	assert.Equal(t, map[string]int{"docker.io/library/api:latest": 1, "docker.io/library/db:latest": 1}, puller.pulled)
+
+	err = core.PullImages(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrServiceNotFound)
+}
+
+func TestAceptadora_ParallelSubtests(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	a := New(t, newFakePuller(), newTestConfig(t))
+	a.Core().newClient = docker.client
+	a.Run(ctx, "db")
+
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			name := fmt.Sprintf("api-%d", i)
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				child := a.ForTest(t)
+				child.Run(ctx, "api", WithContainerName(name))
+				assert.NotEmpty(t, child.Endpoint(name, "80"))
+				// the services of the parent are visible through the child
+				assert.NotEmpty(t, child.Endpoint("db", "80"))
+				child.WaitForLog(ctx, name, "started")
+			})
+		}
+	})
+
+	// the services run by the children were stopped when their tests finished
+	assert.Equal(t, map[string]bool{"db": true}, docker.running())
+}
@@ -0,0 +1,79 @@
+package aceptadora
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// resolveDependencies returns the provided services together with all their transitive dependencies,
+// sorted in a topological order: every service comes after all the services it depends on.
+// It fails if some service is not defined or if there's a dependency cycle.
+func (y YAML) resolveDependencies(names []string) ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var order []string
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := append(path[slices.Index(path, name):], name)
+			return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+
+		svc, ok := y.Services[name]
+		if !ok {
+			if len(path) > 0 {
+				return fmt.Errorf("service %q depends on %q, which is not defined", path[len(path)-1], name)
+			}
+			return fmt.Errorf("there's no service with name %q", name)
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range svc.DependsOn.names() {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// serviceNames returns the names of all the services defined, sorted alphabetically
+func (y YAML) serviceNames() []string {
+	names := make([]string, 0, len(y.Services))
+	for name := range y.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// names returns the names of the dependencies sorted alphabetically, so the resolution is deterministic.
+func (d Dependencies) names() []string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
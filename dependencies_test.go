@@ -0,0 +1,186 @@
+package aceptadora
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestYAML_ResolveDependencies(t *testing.T) {
+	// dependsOn builds a service depending on the provided services
+	dependsOn := func(names ...string) Service {
+		deps := Dependencies{}
+		for _, name := range names {
+			deps[name] = Dependency{Condition: DependencyConditionStarted}
+		}
+		return Service{DependsOn: deps}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		services    map[string]Service
+		run         []string
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name:     "no dependencies",
+			services: map[string]Service{"a": {}, "b": {}},
+			run:      []string{"b", "a"},
+			expected: []string{"b", "a"},
+		},
+		{
+			name: "transitive dependencies come first",
+			services: map[string]Service{
+				"api":   dependsOn("cache", "db"),
+				"cache": dependsOn("db"),
+				"db":    {},
+			},
+			run:      []string{"api"},
+			expected: []string{"db", "cache", "api"},
+		},
+		{
+			name: "shared dependencies are listed once",
+			services: map[string]Service{
+				"api":    dependsOn("db"),
+				"worker": dependsOn("db", "queue"),
+				"db":     {},
+				"queue":  {},
+			},
+			run:      []string{"api", "worker", "db"},
+			expected: []string{"db", "api", "queue", "worker"},
+		},
+		{
+			name: "cycle",
+			services: map[string]Service{
+				"a": dependsOn("b"),
+				"b": dependsOn("c"),
+				"c": dependsOn("a"),
+			},
+			run:         []string{"a"},
+			expectedErr: "dependency cycle detected: a -> b -> c -> a",
+		},
+		{
+			name:        "self dependency",
+			services:    map[string]Service{"a": dependsOn("a")},
+			run:         []string{"a"},
+			expectedErr: "dependency cycle detected: a -> a",
+		},
+		{
+			name:        "undefined dependency",
+			services:    map[string]Service{"api": dependsOn("db")},
+			run:         []string{"api"},
+			expectedErr: `service "api" depends on "db", which is not defined`,
+		},
+		{
+			name:        "undefined service",
+			services:    map[string]Service{"api": {}},
+			run:         []string{"db"},
+			expectedErr: `there's no service with name "db"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			order, err := YAML{Services: tc.services}.resolveDependencies(tc.run)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, order)
+		})
+	}
+}
+
+func TestDependencies_UnmarshalYAML(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		yaml        string
+		expected    Dependencies
+		expectedErr string
+	}{
+		{
+			name: "list",
+			yaml: "[db, cache]",
+			expected: Dependencies{
+				"db":    {Condition: DependencyConditionStarted},
+				"cache": {Condition: DependencyConditionStarted},
+			},
+		},
+		{
+			name: "map",
+			yaml: "{db: {condition: service_healthy}, migrations: {condition: service_completed_successfully}, cache: {condition: service_started}}",
+			expected: Dependencies{
+				"db":         {Condition: DependencyConditionHealthy},
+				"migrations": {Condition: DependencyConditionCompletedSuccessfully},
+				"cache":      {Condition: DependencyConditionStarted},
+			},
+		},
+		{
+			name:     "map with the default condition",
+			yaml:     "{db: {}}",
+			expected: Dependencies{"db": {Condition: DependencyConditionStarted}},
+		},
+		{
+			name:        "unknown condition",
+			yaml:        "{db: {condition: service_ready}}",
+			expectedErr: `unknown condition "service_ready" for dependency "db"`,
+		},
+		{
+			name:        "neither a list nor a map",
+			yaml:        "db",
+			expectedErr: "cannot unmarshal",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var deps Dependencies
+			err := yaml.Unmarshal([]byte(tc.yaml), &deps)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, deps)
+		})
+	}
+}
+
+func TestCore_RunAllStartsTheDependenciesFirst(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mtx sync.Mutex
+	var started, stopped []string
+	docker := newFakeDocker()
+	docker.onStart = func(name string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		started = append(started, name)
+	}
+	docker.onStop = func(name string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		stopped = append(stopped, name)
+	}
+
+	core, err := NewCore(t, newFakePuller(), newTestConfigWithDependencies(t, map[string][]string{
+		"worker": {"queue", "db"},
+		"queue":  {"db"},
+	}))
+	require.NoError(t, err)
+	core.newClient = docker.client
+
+	require.NoError(t, core.RunAll(ctx, "worker"))
+	assert.Equal(t, []string{"db", "queue", "worker"}, started)
+	assert.Empty(t, docker.running()["api"], "services that aren't dependencies are not started")
+
+	require.NoError(t, core.StopAll(ctx))
+	slices.Reverse(started)
+	assert.Equal(t, started, stopped)
+}
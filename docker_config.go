@@ -0,0 +1,198 @@
+package aceptadora
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+const (
+	// dockerIndexServer is the address used by the docker CLI to store the credentials of docker.io
+	dockerIndexServer = "https://index.docker.io/v1/"
+	// credentialsNotFound is the output of the credential helpers when they don't have credentials for a registry
+	credentialsNotFound = "credentials not found in native keychain"
+)
+
+var errCredentialsNotFound = errors.New(credentialsNotFound)
+
+// dockerConfig is the part of the config.json of the docker CLI with the credentials of the registries
+type dockerConfig struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// dockerConfigDir returns the directory of the config.json of the docker CLI:
+// the configured one, $DOCKER_CONFIG or ~/.docker, like the docker CLI does.
+func dockerConfigDir(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("can't find the docker config dir: %w", err)
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// loadDockerConfig reads the config.json from the provided dir, returning an empty config if it doesn't exist
+func loadDockerConfig(dir string) (dockerConfig, error) {
+	path := filepath.Join(dir, "config.json")
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return dockerConfig{}, nil
+	} else if err != nil {
+		return dockerConfig{}, fmt.Errorf("can't read docker config %s: %w", path, err)
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return dockerConfig{}, fmt.Errorf("can't decode docker config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// registryHostname returns the hostname of a registry address, like `https://registry.example.com/v1/` or `registry.example.com`,
+// which is how the docker CLI matches the addresses of its config with the domains of the images.
+func registryHostname(address string) string {
+	address = strings.TrimPrefix(address, "http://")
+	address = strings.TrimPrefix(address, "https://")
+	hostname, _, _ := strings.Cut(address, "/")
+	if hostname == "docker.io" {
+		return "index.docker.io"
+	}
+	return hostname
+}
+
+// registryServerAddress returns the address of the registry of the domain, as stored by the credential helpers
+func registryServerAddress(domain string) string {
+	if domain == "docker.io" {
+		return dockerIndexServer
+	}
+	return domain
+}
+
+// credentialHelper returns the name of the credential helper for the domain:
+// the one of its credHelpers entry, or the credsStore, or none.
+func (cfg dockerConfig) credentialHelper(domain string) string {
+	for address, helper := range cfg.CredHelpers {
+		if registryHostname(address) == registryHostname(domain) {
+			return helper
+		}
+	}
+	return cfg.CredsStore
+}
+
+// auth returns the credentials of the auths entry for the domain, if any
+func (cfg dockerConfig) auth(domain string) (registry.AuthConfig, bool, error) {
+	for address, entry := range cfg.Auths {
+		if registryHostname(address) != registryHostname(domain) {
+			continue
+		}
+		auth := registry.AuthConfig{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			ServerAddress: registryServerAddress(domain),
+			IdentityToken: entry.IdentityToken,
+			RegistryToken: entry.RegistryToken,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return registry.AuthConfig{}, false, fmt.Errorf("can't decode the auth of %s in docker config: %w", address, err)
+			}
+			var ok bool
+			auth.Username, auth.Password, ok = strings.Cut(string(decoded), ":")
+			if !ok {
+				return registry.AuthConfig{}, false, fmt.Errorf("invalid auth of %s in docker config: it should be username:password", address)
+			}
+		}
+		return auth, true, nil
+	}
+	return registry.AuthConfig{}, false, nil
+}
+
+// credentialHelperOutput is what the credential helpers write when asked for the credentials of a registry
+type credentialHelperOutput struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// execCredentialHelper runs `docker-credential-<helper> get` for the server address,
+// returning errCredentialsNotFound if the helper doesn't have credentials for it.
+func execCredentialHelper(ctx context.Context, helper, serverAddress string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if strings.TrimSpace(stdout.String()) == credentialsNotFound {
+			return nil, errCredentialsNotFound
+		}
+		return nil, fmt.Errorf("running docker-credential-%s: %w: %s%s", helper, err, stdout.String(), stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// dockerConfigAuth returns the credentials for the domain from the docker CLI config:
+// from its credential helper, or from its auths entry if there's no helper or it doesn't have them.
+func (i *ImagePullerImpl) dockerConfigAuth(ctx context.Context, domain string) (registry.AuthConfig, bool, error) {
+	i.dockerConfigOnce.Do(func() {
+		var dir string
+		dir, i.dockerConfigErr = dockerConfigDir(i.cfg.DockerConfigDir)
+		if i.dockerConfigErr == nil {
+			i.dockerConfig, i.dockerConfigErr = loadDockerConfig(dir)
+		}
+	})
+	if i.dockerConfigErr != nil {
+		return registry.AuthConfig{}, false, i.dockerConfigErr
+	}
+
+	if helper := i.dockerConfig.credentialHelper(domain); helper != "" {
+		serverAddress := registryServerAddress(domain)
+		out, err := i.credentialHelper(ctx, helper, serverAddress)
+		switch {
+		case errors.Is(err, errCredentialsNotFound):
+			// fall back to the auths, like the docker CLI does
+		case err != nil:
+			return registry.AuthConfig{}, false, fmt.Errorf("can't get the credentials for %s from docker-credential-%s: %w", domain, helper, err)
+		default:
+			var creds credentialHelperOutput
+			if err := json.Unmarshal(out, &creds); err != nil {
+				return registry.AuthConfig{}, false, fmt.Errorf("can't decode the credentials for %s from docker-credential-%s: %w", domain, helper, err)
+			}
+			auth := registry.AuthConfig{ServerAddress: serverAddress}
+			if creds.Username == "<token>" {
+				auth.IdentityToken = creds.Secret
+			} else {
+				auth.Username, auth.Password = creds.Username, creds.Secret
+			}
+			return auth, true, nil
+		}
+	}
+
+	return i.dockerConfig.auth(domain)
+}
@@ -13,17 +13,26 @@ import (
 // SetEnv loads the configuration from a file, choosing the proper one depending on the provided matchers.
 // Every matcher returning true as second value will be executed.
 // Matchers are executed in their provided order
-func SetEnv(t *testing.T, matchers ...ConfigPathMatcher) {
+func SetEnv(t testing.TB, matchers ...ConfigPathMatcher) {
+	err := LoadEnv(t, matchers...)
+	require.NoError(t, err, "Can't load env: %s", err)
+}
+
+// LoadEnv is like SetEnv, but it returns an error instead of failing the test.
+func LoadEnv(log Logger, matchers ...ConfigPathMatcher) error {
 	for _, f := range matchers {
 		if path, shouldBeUsed := f(); shouldBeUsed {
 			env, err := loadConfigFromFile(path)
-			require.NoError(t, err, "Can't load config from %s: %s", path, err)
+			if err != nil {
+				return fmt.Errorf("can't load config from %s: %w", path, err)
+			}
 			for k, v := range env {
 				os.Setenv(k, v)
 			}
-			t.Logf("Loaded env from %q", path)
+			log.Logf("Loaded env from %q", path)
 		}
 	}
+	return nil
 }
 
 type ConfigPathMatcher func() (path string, use bool)
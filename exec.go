@@ -0,0 +1,128 @@
+package aceptadora
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ExecConfig defines a command to be executed inside of a running container
+type ExecConfig struct {
+	// Cmd is the command to execute and its arguments
+	Cmd []string
+	// Env provides additional env vars for the command
+	Env map[string]string
+	// WorkingDir is the directory the command is executed in, the container's one if empty
+	WorkingDir string
+	// User is the user that executes the command, the container's one if empty
+	User string
+	// Stdin, if provided, will be written to the standard input of the command, closing it when consumed
+	Stdin io.Reader
+	// Stdout and Stderr, if provided, receive the output of the command as it's written, instead of ExecResult
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ExecResult holds the outcome of a command executed inside of a container
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Exec executes the provided command inside of the running container and waits until it finishes.
+// A non-zero exit code is not considered a failure, it's up to the caller to check it.
+func (r *Runner) Exec(ctx context.Context, cmd ...string) ExecResult {
+	return r.ExecWithConfig(ctx, ExecConfig{Cmd: cmd})
+}
+
+// ExecWithConfig executes the command defined by the provided config inside of the running container and waits until it finishes.
+// A non-zero exit code is not considered a failure, it's up to the caller to check it.
+func (r *Runner) ExecWithConfig(ctx context.Context, cfg ExecConfig) ExecResult {
+	res, err := r.exec(ctx, cfg)
+	r.require.NoError(err, "Can't exec %q in %q: %s", cfg.Cmd, r.name, err)
+	return res
+}
+
+// ExecInContainer executes the command defined by the provided config inside of a running container, found by its ID or name,
+// like Runner.ExecWithConfig does. It's useful for the containers that weren't run by this process, like the ones of the CLI.
+func ExecInContainer(ctx context.Context, containerID string, cfg ExecConfig) (ExecResult, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("creating docker client: %w", err)
+	}
+	return execInContainer(ctx, cli, containerID, cfg)
+}
+
+func (r *Runner) exec(ctx context.Context, cfg ExecConfig) (ExecResult, error) {
+	return execInContainer(ctx, r.client, r.container.ID, cfg)
+}
+
+func execInContainer(ctx context.Context, cli dockerClient, containerID string, cfg ExecConfig) (ExecResult, error) {
+	created, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cfg.Cmd,
+		Env:          flatten(cfg.Env),
+		WorkingDir:   cfg.WorkingDir,
+		User:         cfg.User,
+		AttachStdin:  cfg.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("can't create exec: %w", err)
+	}
+
+	resp, err := cli.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("can't attach to exec: %w", err)
+	}
+	defer resp.Close()
+
+	stdinErrCh := make(chan error, 1)
+	if cfg.Stdin != nil {
+		go func() {
+			_, err := io.Copy(resp.Conn, cfg.Stdin)
+			if closeErr := resp.CloseWrite(); err == nil {
+				err = closeErr
+			}
+			stdinErrCh <- err
+		}()
+	} else {
+		stdinErrCh <- nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	stdoutW, stderrW := io.Writer(&stdout), io.Writer(&stderr)
+	if cfg.Stdout != nil {
+		stdoutW = cfg.Stdout
+	}
+	if cfg.Stderr != nil {
+		stderrW = cfg.Stderr
+	}
+	if _, err := stdcopy.StdCopy(stdoutW, stderrW, resp.Reader); err != nil {
+		return ExecResult{}, fmt.Errorf("can't read output of exec: %w", err)
+	}
+	select {
+	case err := <-stdinErrCh:
+		if err != nil {
+			return ExecResult{}, fmt.Errorf("can't write stdin of exec: %w", err)
+		}
+	default:
+		// the command finished before consuming its stdin, which might never end, like an interactive terminal
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("can't inspect exec: %w", err)
+	}
+
+	return ExecResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: inspect.ExitCode,
+	}, nil
+}
@@ -0,0 +1,478 @@
+package aceptadora
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var errNotImplementedByFake = errors.New("not implemented by the fake docker")
+
+// fakeDocker is an in-memory docker runtime implementing dockerClient.
+// Its containers don't run anything: they just log a line when they're started, and publish their ports on fake host ports.
+type fakeDocker struct {
+	// onStart, if not nil, is called when a container is being started, before it's running
+	onStart func(name string)
+	// onStop, if not nil, is called when a container is being stopped
+	onStop func(name string)
+	// onConnect, if not nil, is called when a container is being connected to a network, failing the connection if it returns an error
+	onConnect func(network, name string) error
+	// listErr, if not nil, is returned when listing the containers
+	listErr error
+	// startErr, if not nil, is returned when starting a container
+	startErr error
+	// stopKeepsLogs keeps streaming the logs of the containers once they're stopped, instead of ending the stream
+	stopKeepsLogs bool
+	// hostPort, if not nil, provides the host port where a port of a container is published, instead of a fake one
+	hostPort func(name string, port nat.Port) string
+	// startLogs, if not nil, provides the entries logged by a container each time it's started, instead of "<name> started"
+	startLogs func(name string) []LogEntry
+
+	mtx        sync.Mutex
+	containers map[string]*fakeContainer
+	networks   map[string]bool
+	nextID     int
+	nextPort   int
+}
+
+type fakeContainer struct {
+	id     string
+	name   string
+	labels map[string]string
+	ports  nat.PortMap
+	config *container.Config
+	host   *container.HostConfig
+	// networks are the networks the container is connected to
+	networks map[string]bool
+	running  bool
+	paused   bool
+	// logs is where the logs of the container are written, it's closed when the container is stopped
+	logs net.Conn
+}
+
+func newFakeDocker() *fakeDocker {
+	return &fakeDocker{
+		containers: map[string]*fakeContainer{},
+		networks:   map[string]bool{},
+		nextPort:   30000,
+	}
+}
+
+// client returns the fake as the docker client for the runners
+func (d *fakeDocker) client() (dockerClient, error) {
+	return d, nil
+}
+
+// running returns the names of the containers that are running
+func (d *fakeDocker) running() map[string]bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	running := map[string]bool{}
+	for _, c := range d.containers {
+		if c.running {
+			running[c.name] = true
+		}
+	}
+	return running
+}
+
+// partitionNetworks returns the names of the networks created for the partitions, sorted
+func (d *fakeDocker) partitionNetworks() []string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var networks []string
+	for network := range d.networks {
+		if strings.HasPrefix(network, partitionNetworkPrefix) {
+			networks = append(networks, network)
+		}
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+// containerNamed returns the container with the provided name, if it exists
+func (d *fakeDocker) containerNamed(name string) (*fakeContainer, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, c := range d.containers {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// networksOf returns the networks the container with the provided name is connected to
+func (d *fakeDocker) networksOf(name string) []string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var networks []string
+	for _, c := range d.containers {
+		if c.name == name {
+			for network := range c.networks {
+				networks = append(networks, network)
+			}
+		}
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+// created returns the amount of containers created, even if they were removed later
+func (d *fakeDocker) created() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.nextID
+}
+
+func (d *fakeDocker) container(id string) (*fakeContainer, error) {
+	c, ok := d.containers[id]
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+	}
+	return c, nil
+}
+
+func (d *fakeDocker) ContainerList(_ context.Context, options container.ListOptions) ([]types.Container, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.listErr != nil {
+		return nil, d.listErr
+	}
+
+	var names []*regexp.Regexp
+	for _, pattern := range options.Filters.Get("name") {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errdefs.InvalidParameter(err)
+		}
+		names = append(names, re)
+	}
+
+	var list []types.Container
+	for _, c := range d.containers {
+		matches := len(names) == 0
+		for _, re := range names {
+			matches = matches || re.MatchString("/"+c.name)
+		}
+		if matches && (options.All || c.running) {
+			list = append(list, types.Container{ID: c.id, Names: []string{"/" + c.name}, Labels: c.labels})
+		}
+	}
+	return list, nil
+}
+
+func (d *fakeDocker) ContainerRemove(_ context.Context, containerID string, _ container.RemoveOptions) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, err := d.container(containerID)
+	if err != nil {
+		return err
+	}
+	if c.logs != nil {
+		c.logs.Close()
+	}
+	delete(d.containers, containerID)
+	return nil
+}
+
+func (d *fakeDocker) ContainerCreate(_ context.Context, config *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, c := range d.containers {
+		if c.name == containerName {
+			return container.CreateResponse{}, errdefs.Conflict(fmt.Errorf("container name %q is already in use", containerName))
+		}
+	}
+
+	d.nextID++
+	c := &fakeContainer{
+		id:       strconv.Itoa(d.nextID),
+		name:     containerName,
+		labels:   config.Labels,
+		ports:    nat.PortMap{},
+		config:   config,
+		host:     hostConfig,
+		networks: map[string]bool{},
+	}
+	for port := range hostConfig.PortBindings {
+		d.nextPort++
+		hostPort := strconv.Itoa(d.nextPort)
+		if d.hostPort != nil {
+			hostPort = d.hostPort(containerName, port)
+		}
+		c.ports[port] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}}
+	}
+	d.containers[c.id] = c
+	return container.CreateResponse{ID: c.id}, nil
+}
+
+func (d *fakeDocker) ContainerAttach(_ context.Context, containerID string, _ container.AttachOptions) (types.HijackedResponse, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, err := d.container(containerID)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+
+	client, server := net.Pipe()
+	c.logs = server
+	return types.HijackedResponse{Conn: client, Reader: bufio.NewReader(client)}, nil
+}
+
+func (d *fakeDocker) ContainerStart(_ context.Context, containerID string, _ container.StartOptions) error {
+	d.mtx.Lock()
+	c, err := d.container(containerID)
+	d.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if d.onStart != nil {
+		d.onStart(c.name)
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.startErr != nil {
+		return d.startErr
+	}
+	c.running = true
+	logs := c.logs
+	if logs == nil {
+		// nobody is attached to the container
+		return nil
+	}
+	entries := []LogEntry{{Stream: Stdout, Line: c.name + " started"}}
+	if d.startLogs != nil {
+		entries = d.startLogs(c.name)
+	}
+	go func() {
+		// net.Pipe blocks until the lines are read, so they're written asynchronously
+		streams := map[LogStream]io.Writer{
+			Stdout: stdcopy.NewStdWriter(logs, stdcopy.Stdout),
+			Stderr: stdcopy.NewStdWriter(logs, stdcopy.Stderr),
+		}
+		for _, e := range entries {
+			if _, err := fmt.Fprintln(streams[e.Stream], e.Line); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (d *fakeDocker) ContainerInspect(_ context.Context, containerID string) (types.ContainerJSON, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, err := d.container(containerID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    c.id,
+			Name:  "/" + c.name,
+			State: &types.ContainerState{Running: c.running, Paused: c.paused},
+		},
+		NetworkSettings: &types.NetworkSettings{NetworkSettingsBase: types.NetworkSettingsBase{Ports: c.ports}},
+	}, nil
+}
+
+func (d *fakeDocker) ContainerStop(_ context.Context, containerID string, _ container.StopOptions) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, err := d.container(containerID)
+	if err != nil {
+		return err
+	}
+	if d.onStop != nil {
+		d.onStop(c.name)
+	}
+	c.running = false
+	if c.logs != nil && !d.stopKeepsLogs {
+		c.logs.Close()
+	}
+	return nil
+}
+
+func (d *fakeDocker) ContainerPause(_ context.Context, containerID string) error {
+	return d.setPaused(containerID, true)
+}
+
+func (d *fakeDocker) ContainerUnpause(_ context.Context, containerID string) error {
+	return d.setPaused(containerID, false)
+}
+
+func (d *fakeDocker) setPaused(containerID string, paused bool) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, err := d.container(containerID)
+	if err != nil {
+		return err
+	}
+	if !c.running {
+		return errdefs.Conflict(fmt.Errorf("container %s is not running", containerID))
+	}
+	c.paused = paused
+	return nil
+}
+
+func (d *fakeDocker) ContainerKill(context.Context, string, string) error {
+	return errNotImplementedByFake
+}
+
+func (d *fakeDocker) ContainerWait(context.Context, string, container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	errCh := make(chan error, 1)
+	errCh <- errNotImplementedByFake
+	return nil, errCh
+}
+
+func (d *fakeDocker) ContainerLogs(context.Context, string, container.LogsOptions) (io.ReadCloser, error) {
+	return nil, errNotImplementedByFake
+}
+
+func (d *fakeDocker) ContainerExecCreate(context.Context, string, container.ExecOptions) (types.IDResponse, error) {
+	return types.IDResponse{}, errNotImplementedByFake
+}
+
+func (d *fakeDocker) ContainerExecAttach(context.Context, string, container.ExecAttachOptions) (types.HijackedResponse, error) {
+	return types.HijackedResponse{}, errNotImplementedByFake
+}
+
+func (d *fakeDocker) ContainerExecInspect(context.Context, string) (container.ExecInspect, error) {
+	return container.ExecInspect{}, errNotImplementedByFake
+}
+
+func (d *fakeDocker) CopyToContainer(context.Context, string, string, io.Reader, container.CopyToContainerOptions) error {
+	return errNotImplementedByFake
+}
+
+func (d *fakeDocker) CopyFromContainer(context.Context, string, string) (io.ReadCloser, container.PathStat, error) {
+	return nil, container.PathStat{}, errNotImplementedByFake
+}
+
+func (d *fakeDocker) NetworkInspect(_ context.Context, networkID string, _ network.InspectOptions) (network.Inspect, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if !d.networks[networkID] {
+		return network.Inspect{}, errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+	return network.Inspect{ID: networkID, Name: networkID}, nil
+}
+
+func (d *fakeDocker) NetworkCreate(_ context.Context, name string, _ network.CreateOptions) (network.CreateResponse, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.networks[name] {
+		return network.CreateResponse{}, errdefs.Conflict(fmt.Errorf("network with name %s already exists", name))
+	}
+	d.networks[name] = true
+	return network.CreateResponse{ID: name}, nil
+}
+
+func (d *fakeDocker) NetworkConnect(_ context.Context, networkID, containerID string, _ *network.EndpointSettings) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, err := d.networkContainer(networkID, containerID)
+	if err != nil {
+		return err
+	}
+	if c.networks[networkID] {
+		return errdefs.Forbidden(fmt.Errorf("endpoint with name %s already exists in network %s", c.name, networkID))
+	}
+	if d.onConnect != nil {
+		if err := d.onConnect(networkID, c.name); err != nil {
+			return err
+		}
+	}
+	c.networks[networkID] = true
+	return nil
+}
+
+func (d *fakeDocker) NetworkDisconnect(_ context.Context, networkID, containerID string, _ bool) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	c, err := d.networkContainer(networkID, containerID)
+	if err != nil {
+		return err
+	}
+	if !c.networks[networkID] {
+		return errdefs.Forbidden(fmt.Errorf("container %s is not connected to network %s", c.name, networkID))
+	}
+	delete(c.networks, networkID)
+	return nil
+}
+
+func (d *fakeDocker) networkContainer(networkID, containerID string) (*fakeContainer, error) {
+	if !d.networks[networkID] {
+		return nil, errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+	return d.container(containerID)
+}
+
+func (d *fakeDocker) NetworkRemove(_ context.Context, networkID string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if !d.networks[networkID] {
+		return errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+	delete(d.networks, networkID)
+	return nil
+}
+
+// fakePuller pulls nothing, counting the pulls of each image, and how many of them were pulled at the same time
+type fakePuller struct {
+	// delay is the time each pull takes
+	delay time.Duration
+	// fail are the images that can't be pulled
+	fail map[string]bool
+
+	mtx         sync.Mutex
+	pulled      map[string]int
+	inFlight    int
+	maxInFlight int
+}
+
+func newFakePuller() *fakePuller {
+	return &fakePuller{pulled: map[string]int{}}
+}
+
+func (p *fakePuller) Pull(ctx context.Context, imageName string) {
+	_ = p.TryPull(ctx, imageName)
+}
+
+func (p *fakePuller) TryPull(_ context.Context, imageName string) error {
+	p.mtx.Lock()
+	p.pulled[imageName]++
+	p.inFlight++
+	p.maxInFlight = max(p.maxInFlight, p.inFlight)
+	p.mtx.Unlock()
+
+	time.Sleep(p.delay)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.inFlight--
+	if p.fail[imageName] {
+		return fmt.Errorf("image %s not found", imageName)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package aceptadora
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// keepOnFailureEnvVar enables Config.KeepOnFailure when it's set to a true value
+const keepOnFailureEnvVar = "ACEPTADORA_KEEP_ON_FAILURE"
+
+// keepOnFailure returns true if the services should be left running when the test fails
+func (c *Core) keepOnFailure() bool {
+	if c.cfg.KeepOnFailure {
+		return true
+	}
+	value, ok := os.LookupEnv(keepOnFailureEnvVar)
+	if !ok {
+		return false
+	}
+	keep, err := strconv.ParseBool(value)
+	if err != nil {
+		c.log.Logf("Ignoring invalid value %q of %s: %s", value, keepOnFailureEnvVar, err)
+	}
+	return keep
+}
+
+// logKeptServices logs the containers of the running services, and the commands to inspect them.
+// They're logged only once, as StopAll is called again when the test finishes.
+func (c *Core) logKeptServices() {
+	c.mtx.Lock()
+	if c.keptLogged {
+		c.mtx.Unlock()
+		return
+	}
+	c.keptLogged = true
+	var runners []*Runner
+	for _, name := range c.order {
+		if runner := c.services[name]; runner.isRunning() {
+			runners = append(runners, runner)
+		}
+	}
+	c.mtx.Unlock()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Test failed, keeping %d services running for debugging:\n", len(runners))
+	for _, r := range runners {
+		fmt.Fprintf(&sb, "  %s: container %s (%s)\n", r.name, r.containerName(), r.container.ID)
+		if ports := r.publishedPorts(); len(ports) > 0 {
+			fmt.Fprintf(&sb, "    ports: %s\n", strings.Join(ports, ", "))
+		}
+		fmt.Fprintf(&sb, "    docker logs %s\n", r.containerName())
+		fmt.Fprintf(&sb, "    docker exec -it %s sh\n", r.containerName())
+	}
+	if c.cfg.Reaper.Enabled {
+		sb.WriteString("The reaper is enabled, so they will be removed anyway once the test process ends.\n")
+	} else {
+		fmt.Fprintf(&sb, "Remove them with `docker rm -f` or with `aceptadora -session %q down`.\n", c.cfg.SessionID)
+	}
+	c.log.Logf("%s", sb.String())
+}
+
+// publishedPorts returns the container ports published by the container with the addresses they're reachable at
+func (r *Runner) publishedPorts() []string {
+	r.mtx.Lock()
+	published := make([]nat.Port, 0, len(r.ports))
+	for port := range r.ports {
+		published = append(published, port)
+	}
+	r.mtx.Unlock()
+
+	var ports []string
+	for _, port := range published {
+		if addr, err := r.endpoint(string(port)); err == nil {
+			ports = append(ports, fmt.Sprintf("%s -> %s", port, addr))
+		}
+	}
+	sort.Strings(ports)
+	return ports
+}
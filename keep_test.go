@@ -0,0 +1,90 @@
+package aceptadora
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failedTB is the testing.TB of a test that has failed, recording what's logged into it
+type failedTB struct {
+	testing.TB
+	log recordingLogger
+}
+
+func (tb *failedTB) Failed() bool {
+	return true
+}
+
+func (tb *failedTB) Logf(format string, args ...any) {
+	tb.log.Logf(format, args...)
+}
+
+func TestAceptadora_StopAllKeepsTheServicesWhenTheTestFailed(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		keepOnFailure bool
+		env           string
+		failed        bool
+		expectKept    bool
+	}{
+		{name: "kept when failed", keepOnFailure: true, failed: true, expectKept: true},
+		{name: "kept when failed with the env var", env: "true", failed: true, expectKept: true},
+		{name: "stopped when the test didn't fail", keepOnFailure: true},
+		{name: "stopped when failed but not enabled", failed: true},
+		{name: "stopped when failed but disabled by the env var", env: "false", failed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if tc.env != "" {
+				t.Setenv(keepOnFailureEnvVar, tc.env)
+			}
+
+			var tb testing.TB = t
+			log := &recordingLogger{}
+			if tc.failed {
+				failed := &failedTB{TB: t}
+				tb, log = failed, &failed.log
+			}
+			docker := newFakeDocker()
+			cfg := newTestConfig(t)
+			cfg.KeepOnFailure = tc.keepOnFailure
+			a := New(tb, newFakePuller(), cfg)
+			a.Core().newClient = docker.client
+			a.Run(ctx, "db")
+			a.Run(ctx, "api")
+
+			a.StopAll(ctx)
+			if !tc.expectKept {
+				assert.Empty(t, docker.running())
+				assert.Empty(t, log.containing("keeping"))
+				return
+			}
+			assert.Equal(t, map[string]bool{"api": true, "db": true}, docker.running())
+
+			kept := log.containing("Test failed, keeping 2 services running for debugging")
+			require.Len(t, kept, 1)
+			for _, name := range []string{"api", "db"} {
+				c, ok := docker.containerNamed(name)
+				require.True(t, ok)
+				endpoint, err := a.Core().Endpoint(name, "80")
+				require.NoError(t, err)
+				assert.Contains(t, kept[0], name+": container "+name+" ("+c.id+")")
+				assert.Contains(t, kept[0], "ports: 80/tcp -> "+endpoint)
+				assert.Contains(t, kept[0], "docker logs "+name)
+				assert.Contains(t, kept[0], "docker exec -it "+name+" sh")
+			}
+			assert.Less(t, strings.Index(kept[0], "db:"), strings.Index(kept[0], "api:"), "services are listed in the order they were run")
+
+			// StopAll is called again when the test finishes, which doesn't log them again
+			a.StopAll(ctx)
+			assert.Len(t, log.containing("keeping"), 1)
+			assert.Len(t, docker.running(), 2)
+		})
+	}
+}
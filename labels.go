@@ -0,0 +1,48 @@
+package aceptadora
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Labels set by aceptadora on the containers it creates, allowing to identify them.
+// Networks created by aceptadora, and the reaper container, are labelled with LabelSession and LabelRun.
+const (
+	// LabelSession holds the Config.SessionID, which might be empty
+	LabelSession = "com.cabify.aceptadora.session"
+	// LabelService holds the name the service was run with
+	LabelService = "com.cabify.aceptadora.service"
+	// LabelYAML holds the path of the aceptadora.yml the service was defined in
+	LabelYAML = "com.cabify.aceptadora.yaml"
+	// LabelConfigHash holds a hash of the service definition the container was created from
+	LabelConfigHash = "com.cabify.aceptadora.config-hash"
+	// LabelRun holds a random identifier of the Aceptadora that created the resource, used by the reaper
+	LabelRun = "com.cabify.aceptadora.run"
+)
+
+// containerLabels returns the labels for the container of the runner
+func (r *Runner) containerLabels() map[string]string {
+	return map[string]string{
+		LabelSession:    r.session,
+		LabelService:    r.name,
+		LabelYAML:       r.yamlPath,
+		LabelConfigHash: configHash(r.svc),
+		LabelRun:        r.run,
+	}
+}
+
+// networkLabels returns the labels for the networks created by the runner
+func (r *Runner) networkLabels() map[string]string {
+	return map[string]string{
+		LabelSession: r.session,
+		LabelRun:     r.run,
+	}
+}
+
+// configHash returns a short hash of the service definition
+func configHash(svc Service) string {
+	data, _ := json.Marshal(svc)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
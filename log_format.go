@@ -0,0 +1,135 @@
+package aceptadora
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LogFormat defines how the lines logged by a container are decoded
+type LogFormat string
+
+const (
+	// LogFormatText doesn't decode the lines, this is the default
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON decodes each line as a JSON object, lines that can't be decoded are kept as text
+	LogFormatJSON LogFormat = "json"
+)
+
+func (f LogFormat) validate() error {
+	switch f {
+	case "", LogFormatText, LogFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, should be %q or %q", f, LogFormatText, LogFormatJSON)
+	}
+}
+
+// UnmarshalYAML validates the log format
+func (f *LogFormat) UnmarshalYAML(node *yaml.Node) error {
+	var format string
+	if err := node.Decode(&format); err != nil {
+		return err
+	}
+	*f = LogFormat(format)
+	return f.validate()
+}
+
+// Well-known names of the level, message and time fields of JSON logs, in order of preference
+var (
+	levelFields   = []string{"level", "lvl", "severity"}
+	messageFields = []string{"msg", "message"}
+	timeFields    = []string{"time", "ts", "timestamp", "@timestamp"}
+)
+
+// colors are the ANSI colors used to colorize the services
+var colors = []string{"\x1b[31m", "\x1b[32m", "\x1b[33m", "\x1b[34m", "\x1b[35m", "\x1b[36m"}
+
+const colorReset = "\x1b[0m"
+
+// decodeLogEntry decodes the line according to the `log_format` of the service
+func (r *Runner) decodeLogEntry(stream LogStream, line string) LogEntry {
+	e := LogEntry{Stream: stream, Line: line}
+	if r.svc.LogFormat != LogFormatJSON || !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return e
+	}
+	if err := json.Unmarshal([]byte(line), &e.Fields); err != nil {
+		return e
+	}
+	e.Level, _ = lookupField(e.Fields, levelFields).(string)
+	e.Message, _ = lookupField(e.Fields, messageFields).(string)
+	return e
+}
+
+// formatLogEntry formats the decoded entry as `LEVEL message field=value...`, or returns its line as is if it wasn't decoded.
+// Only the `log_fields` of the service are formatted if they're defined, otherwise all the fields are, except the time.
+func (r *Runner) formatLogEntry(e LogEntry) string {
+	if e.Fields == nil {
+		return e.Line
+	}
+
+	var sb strings.Builder
+	if e.Level != "" {
+		sb.WriteString(strings.ToUpper(e.Level))
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(e.Message)
+
+	names := r.svc.LogFields
+	if len(names) == 0 {
+		skip := map[string]bool{}
+		for _, f := range append(append(append([]string{}, levelFields...), messageFields...), timeFields...) {
+			skip[f] = true
+		}
+		for name := range e.Fields {
+			if !skip[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if value, ok := e.Fields[name]; ok {
+			fmt.Fprintf(&sb, " %s=%s", name, formatLogValue(value))
+		}
+	}
+	return sb.String()
+}
+
+// colorize wraps the text with the color of the service, if colors are enabled
+func (r *Runner) colorize(text string) string {
+	if !r.logColors {
+		return text
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(r.name))
+	return colors[h.Sum32()%uint32(len(colors))] + text + colorReset
+}
+
+func lookupField(fields map[string]any, names []string) any {
+	for _, name := range names {
+		if value, ok := fields[name]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+func formatLogValue(value any) string {
+	if s, ok := value.(string); ok {
+		if strings.ContainsAny(s, " =\"\t\n") || s == "" {
+			return strconv.Quote(s)
+		}
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,185 @@
+package aceptadora
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRunner_DecodeAndFormatLogEntry(t *testing.T) {
+	const jsonLine = `{"level":"info","msg":"started","time":"2024-01-01T00:00:00Z","port":80,"user":"john doe","empty":"","tags":["a","b"]}`
+
+	for _, tc := range []struct {
+		name              string
+		format            LogFormat
+		fields            []string
+		line              string
+		expectedFields    map[string]any
+		expectedLevel     string
+		expectedMessage   string
+		expectedFormatted string
+	}{
+		{
+			name:              "text by default",
+			line:              jsonLine,
+			expectedFormatted: jsonLine,
+		},
+		{
+			name:              "text",
+			format:            LogFormatText,
+			line:              jsonLine,
+			expectedFormatted: jsonLine,
+		},
+		{
+			name:   "json",
+			format: LogFormatJSON,
+			line:   jsonLine,
+			expectedFields: map[string]any{
+				"level": "info", "msg": "started", "time": "2024-01-01T00:00:00Z",
+				"port": float64(80), "user": "john doe", "empty": "", "tags": []any{"a", "b"},
+			},
+			expectedLevel:     "info",
+			expectedMessage:   "started",
+			expectedFormatted: `INFO started empty="" port=80 tags=["a","b"] user="john doe"`,
+		},
+		{
+			name:              "json with alternative field names",
+			format:            LogFormatJSON,
+			line:              `{"severity":"warn","message":"slow query","ts":1700000000,"ms":12.5}`,
+			expectedFields:    map[string]any{"severity": "warn", "message": "slow query", "ts": float64(1700000000), "ms": 12.5},
+			expectedLevel:     "warn",
+			expectedMessage:   "slow query",
+			expectedFormatted: "WARN slow query ms=12.5",
+		},
+		{
+			name:              "json without level",
+			format:            LogFormatJSON,
+			line:              `{"msg":"started","port":80}`,
+			expectedFields:    map[string]any{"msg": "started", "port": float64(80)},
+			expectedMessage:   "started",
+			expectedFormatted: "started port=80",
+		},
+		{
+			name:   "json with log_fields",
+			format: LogFormatJSON,
+			fields: []string{"user", "missing", "time"},
+			line:   jsonLine,
+			expectedFields: map[string]any{
+				"level": "info", "msg": "started", "time": "2024-01-01T00:00:00Z",
+				"port": float64(80), "user": "john doe", "empty": "", "tags": []any{"a", "b"},
+			},
+			expectedLevel:     "info",
+			expectedMessage:   "started",
+			expectedFormatted: `INFO started user="john doe" time=2024-01-01T00:00:00Z`,
+		},
+		{
+			name:              "json falls back to text for lines that aren't objects",
+			format:            LogFormatJSON,
+			line:              "Starting server on :80",
+			expectedFormatted: "Starting server on :80",
+		},
+		{
+			name:              "json falls back to text for invalid json",
+			format:            LogFormatJSON,
+			line:              `{"msg": "truncated`,
+			expectedFormatted: `{"msg": "truncated`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRunner(t, "api", Service{LogFormat: tc.format, LogFields: tc.fields}, newFakePuller())
+			e := r.decodeLogEntry(Stderr, tc.line)
+			assert.Equal(t, Stderr, e.Stream)
+			assert.Equal(t, tc.line, e.Line)
+			assert.Equal(t, tc.expectedFields, e.Fields)
+			assert.Equal(t, tc.expectedLevel, e.Level)
+			assert.Equal(t, tc.expectedMessage, e.Message)
+			assert.Equal(t, tc.expectedFormatted, r.formatLogEntry(e))
+		})
+	}
+}
+
+func TestLogEntryMatchers(t *testing.T) {
+	decoded := LogEntry{
+		Line:   `{"level":"ERROR","msg":"failed","status":500,"user":"john"}`,
+		Fields: map[string]any{"level": "ERROR", "msg": "failed", "status": float64(500), "user": "john"},
+		Level:  "ERROR",
+	}
+	text := LogEntry{Line: "level=error status=500"}
+
+	for _, tc := range []struct {
+		name     string
+		match    func(LogEntry) bool
+		entry    LogEntry
+		expected bool
+	}{
+		{name: "level ignoring the case", match: LogEntryLevel("error"), entry: decoded, expected: true},
+		{name: "other level", match: LogEntryLevel("info"), entry: decoded},
+		{name: "level of a line that wasn't decoded", match: LogEntryLevel("error"), entry: text},
+		{name: "string field", match: LogEntryField("user", "john"), entry: decoded, expected: true},
+		{name: "number field compared with a number", match: LogEntryField("status", 500), entry: decoded, expected: true},
+		{name: "number field compared with a string", match: LogEntryField("status", "500"), entry: decoded, expected: true},
+		{name: "field with another value", match: LogEntryField("user", "jane"), entry: decoded},
+		{name: "missing field", match: LogEntryField("request_id", "1"), entry: decoded},
+		{name: "field of a line that wasn't decoded", match: LogEntryField("status", 500), entry: text},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.match(tc.entry))
+		})
+	}
+}
+
+func TestRunner_Colorize(t *testing.T) {
+	r := newRunner(t, "api", Service{}, newFakePuller())
+	assert.Equal(t, "text", r.colorize("text"), "colors are disabled by default")
+
+	r.logColors = true
+	colorized := r.colorize("text")
+	require.True(t, strings.HasSuffix(colorized, "text"+colorReset), colorized)
+	color := strings.TrimSuffix(colorized, "text"+colorReset)
+	assert.Contains(t, colors, color)
+	assert.Equal(t, colorized, r.colorize("text"), "a service always has the same color")
+
+	// only the name of the container is colorized in the test logs
+	log := &recordingLogger{}
+	r.log = log
+	r.svc.LogFormat = LogFormatJSON
+	r.testLog(r.decodeLogEntry(Stdout, `{"level":"info","msg":"started"}`))
+	assert.Equal(t, []string{"Logs from " + color + `Container "api" STDOUT` + colorReset + ": INFO started"}, log.containing("Logs from"))
+
+	// the services get different colors, even if some of them could collide
+	used := map[string]bool{}
+	for _, name := range []string{"api", "cache", "db", "queue", "worker", "proxy", "redis", "postgres"} {
+		r := newRunner(t, name, Service{}, newFakePuller())
+		r.logColors = true
+		used[strings.TrimSuffix(r.colorize(""), colorReset)] = true
+	}
+	assert.Greater(t, len(used), 1)
+}
+
+func TestLogFormat_UnmarshalYAML(t *testing.T) {
+	for _, tc := range []struct {
+		yaml        string
+		expected    LogFormat
+		expectedErr string
+	}{
+		{yaml: "log_format: json", expected: LogFormatJSON},
+		{yaml: "log_format: text", expected: LogFormatText},
+		{yaml: "image: redis"},
+		{yaml: "log_format: xml", expectedErr: `unknown log format "xml"`},
+	} {
+		t.Run(tc.yaml, func(t *testing.T) {
+			var svc Service
+			err := yaml.Unmarshal([]byte(tc.yaml), &svc)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, svc.LogFormat)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package aceptadora
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logHistory keeps the last lines logged by a container, and notifies the ones waiting for new lines.
+// It's safe for concurrent use.
+type logHistory struct {
+	mtx     sync.Mutex
+	entries []LogEntry
+	// size is the maximum amount of entries kept, all of them are kept if it's zero
+	size int
+	// dropped is the amount of entries dropped because of the size
+	dropped int
+	// added is closed and replaced each time an entry is added, waking up all the waiters
+	added chan struct{}
+}
+
+func newLogHistory() *logHistory {
+	return &logHistory{added: make(chan struct{})}
+}
+
+// limit sets the maximum amount of entries kept, dropping the oldest ones
+func (h *logHistory) limit(size int) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.size = size
+	h.trim()
+}
+
+func (h *logHistory) add(e LogEntry) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.entries = append(h.entries, e)
+	h.trim()
+	close(h.added)
+	h.added = make(chan struct{})
+}
+
+// trim drops the oldest entries above the size.
+// The backing array is reallocated by append once its capacity is exhausted, releasing the dropped entries.
+func (h *logHistory) trim() {
+	if extra := len(h.entries) - h.size; h.size > 0 && extra > 0 {
+		h.entries = h.entries[extra:]
+		h.dropped += extra
+	}
+}
+
+// find returns all the entries matching
+func (h *logHistory) find(match func(LogEntry) bool) []LogEntry {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	var found []LogEntry
+	for _, e := range h.entries {
+		if match(e) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// cursor returns the position of the next entry added, counting the dropped ones
+func (h *logHistory) cursor() int {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.dropped + len(h.entries)
+}
+
+// waitFor returns the first entry matching, waiting until it's added if there's none yet
+func (h *logHistory) waitFor(ctx context.Context, match func(LogEntry) bool) (LogEntry, error) {
+	return h.waitForSince(ctx, 0, match)
+}
+
+// waitForSince is like waitFor, but only matches the entries since the position returned by cursor
+func (h *logHistory) waitForSince(ctx context.Context, since int, match func(LogEntry) bool) (LogEntry, error) {
+	// next is the position of the next entry to match, counting the dropped ones
+	next := since
+	for {
+		h.mtx.Lock()
+		entries, added := h.entries[max(next-h.dropped, 0):], h.added
+		next = h.dropped + len(h.entries)
+		h.mtx.Unlock()
+
+		for _, e := range entries {
+			if match(e) {
+				return e, nil
+			}
+		}
+
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return LogEntry{}, ctx.Err()
+		}
+	}
+}
+
+// logMatcher returns a function matching the entries of the provided streams (all of them if none) that match the pattern
+func logMatcher(pattern string, streams []LogStream) (func(LogEntry) bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(e LogEntry) bool {
+		if len(streams) > 0 && !containsStream(streams, e.Stream) {
+			return false
+		}
+		return re.MatchString(e.Line)
+	}, nil
+}
+
+func containsStream(streams []LogStream, stream LogStream) bool {
+	for _, s := range streams {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitForLog waits until the container logs a line matching the regexp pattern on any of the provided streams,
+// or on any stream if none is provided, and returns that line.
+// The lines logged before calling it are matched too, so it doesn't matter if the line was logged already,
+// as long as it's still kept in the history, see Config.LogHistorySize.
+func (r *Runner) WaitForLog(ctx context.Context, pattern string, streams ...LogStream) string {
+	match, err := logMatcher(pattern, streams)
+	r.require.NoError(err, "Invalid pattern %q: %s", pattern, err)
+
+	e, err := r.logHistory.waitFor(ctx, match)
+	r.require.NoError(err, "%q didn't log a line matching %q: %s", r.name, pattern, err)
+	return e.Line
+}
+
+// AssertNoLog asserts that the container hasn't logged any line matching the regexp pattern on any of the provided streams,
+// or on any stream if none is provided, failing the test (but not stopping it) otherwise.
+// Only the lines kept in the history are checked, see Config.LogHistorySize.
+// It returns true if no line matched.
+func (r *Runner) AssertNoLog(pattern string, streams ...LogStream) bool {
+	match, err := logMatcher(pattern, streams)
+	r.require.NoError(err, "Invalid pattern %q: %s", pattern, err)
+	return r.assertNoLogEntry(match, fmt.Sprintf("matching %q", pattern))
+}
+
+// WaitForLogEntry waits until the container logs an entry for which match returns true, and returns that entry.
+// The entries logged before calling it are matched too.
+// This is useful with the entries decoded according to the `log_format`, like WaitForLogEntry(ctx, LogEntryField("event", "started")).
+func (r *Runner) WaitForLogEntry(ctx context.Context, match func(LogEntry) bool) LogEntry {
+	e, err := r.logHistory.waitFor(ctx, match)
+	r.require.NoError(err, "%q didn't log the expected entry: %s", r.name, err)
+	return e
+}
+
+// AssertNoLogEntry asserts that the container hasn't logged any entry for which match returns true,
+// like AssertNoLogEntry(LogEntryLevel("error")), failing the test (but not stopping it) otherwise.
+// It returns true if no entry matched.
+func (r *Runner) AssertNoLogEntry(match func(LogEntry) bool) bool {
+	return r.assertNoLogEntry(match, "matching")
+}
+
+// LogEntries returns the entries kept in the history of the container for which match returns true, or all of them if match is nil.
+// The oldest entries are dropped once the history reaches Config.LogHistorySize, or the `log_history_size` of the service.
+func (r *Runner) LogEntries(match func(LogEntry) bool) []LogEntry {
+	if match == nil {
+		match = func(LogEntry) bool { return true }
+	}
+	return r.logHistory.find(match)
+}
+
+func (r *Runner) assertNoLogEntry(match func(LogEntry) bool, description string) bool {
+	return assertNoLogEntries(r.t, r.name, r.logHistory.find(match), description)
+}
+
+// assertNoLogEntries fails the test if any entry was found, listing them
+func assertNoLogEntries(t testing.TB, name string, found []LogEntry, description string) bool {
+	if len(found) == 0 {
+		return true
+	}
+	lines := make([]string, 0, len(found))
+	for _, e := range found {
+		lines = append(lines, fmt.Sprintf("%s: %s", strings.ToUpper(string(e.Stream)), e.Line))
+	}
+	return assert.Fail(t, fmt.Sprintf("%q logged %d entries %s", name, len(found), description), strings.Join(lines, "\n"))
+}
+
+// LogEntryLevel matches the entries decoded with the given level, ignoring the case
+func LogEntryLevel(level string) func(LogEntry) bool {
+	return func(e LogEntry) bool {
+		return e.Fields != nil && strings.EqualFold(e.Level, level)
+	}
+}
+
+// LogEntryField matches the entries decoded with a field with the given name and value.
+// Values are compared by their string representation, so numbers can be compared as strings too.
+func LogEntryField(name string, value any) func(LogEntry) bool {
+	return func(e LogEntry) bool {
+		v, ok := e.Fields[name]
+		return ok && fmt.Sprint(v) == fmt.Sprint(value)
+	}
+}
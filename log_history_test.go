@@ -0,0 +1,58 @@
+package aceptadora
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogHistory_KeepsTheLastEntries(t *testing.T) {
+	h := newLogHistory()
+	h.limit(3)
+	for i := 0; i < 10; i++ {
+		h.add(LogEntry{Stream: Stdout, Line: fmt.Sprintf("line %d", i)})
+	}
+
+	all := func(LogEntry) bool { return true }
+	assert.Equal(t, []LogEntry{
+		{Stream: Stdout, Line: "line 7"},
+		{Stream: Stdout, Line: "line 8"},
+		{Stream: Stdout, Line: "line 9"},
+	}, h.find(all))
+
+	h.limit(1)
+	assert.Equal(t, []LogEntry{{Stream: Stdout, Line: "line 9"}}, h.find(all))
+}
+
+func TestLogHistory_WaitForWhileDropping(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := newLogHistory()
+	h.limit(2)
+	h.add(LogEntry{Line: "old"})
+
+	found := make(chan LogEntry)
+	go func() {
+		e, err := h.waitFor(ctx, func(e LogEntry) bool { return e.Line == "expected" })
+		assert.NoError(t, err)
+		found <- e
+	}()
+
+	// the entries are added faster than the waiter wakes up, dropping the ones it didn't see yet
+	for i := 0; i < 100; i++ {
+		h.add(LogEntry{Line: fmt.Sprintf("line %d", i)})
+	}
+	h.add(LogEntry{Line: "expected"})
+
+	select {
+	case e := <-found:
+		assert.Equal(t, "expected", e.Line)
+	case <-ctx.Done():
+		require.Fail(t, "the entry wasn't found")
+	}
+}
@@ -0,0 +1,219 @@
+package aceptadora
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultLogBufferSize is the amount of lines buffered when the log mode is `on_failure` if no other size is provided
+	defaultLogBufferSize = 1000
+	// defaultLogHistorySize is the amount of lines kept for WaitForLog, AssertNoLog and LogEntries if no other size is provided
+	defaultLogHistorySize = 10000
+)
+
+// LogStream identifies an output stream of a container
+type LogStream string
+
+const (
+	Stdout LogStream = "stdout"
+	Stderr LogStream = "stderr"
+)
+
+// LogMode defines when the logs of a container are sent to the test logs
+type LogMode string
+
+const (
+	// LogModeAlways sends the logs to the test logs as soon as they're received
+	LogModeAlways LogMode = "always"
+	// LogModeOnFailure keeps the last logs in a buffer, and sends them to the test logs when the service is stopped
+	// or the test finishes, only if the test has failed
+	LogModeOnFailure LogMode = "on_failure"
+	// LogModeNever doesn't send the logs to the test logs
+	LogModeNever LogMode = "never"
+)
+
+func (m LogMode) validate() error {
+	switch m {
+	case "", LogModeAlways, LogModeOnFailure, LogModeNever:
+		return nil
+	default:
+		return fmt.Errorf("unknown log mode %q, should be %q, %q or %q", m, LogModeAlways, LogModeOnFailure, LogModeNever)
+	}
+}
+
+// UnmarshalYAML validates the log mode
+func (m *LogMode) UnmarshalYAML(node *yaml.Node) error {
+	var mode string
+	if err := node.Decode(&mode); err != nil {
+		return err
+	}
+	*m = LogMode(mode)
+	return m.validate()
+}
+
+// lineWriter is an io.Writer calling fn with each line written to it, without the trailing newline.
+// Flush should be called once nothing else is written, to get the last line if it wasn't terminated.
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+func newLineWriter(fn func(line string)) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.buf = append(w.buf, data...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush calls fn with the remaining unterminated line, if any
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.fn(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// logMode returns the log mode of the service
+func (r *Runner) logMode() LogMode {
+	switch {
+	case r.svc.IgnoreLogs:
+		return LogModeNever
+	case r.svc.LogMode != "":
+		return r.svc.LogMode
+	case r.defaultLogMode != "":
+		return r.defaultLogMode
+	default:
+		return LogModeAlways
+	}
+}
+
+// logBufferSize returns the amount of lines buffered when the log mode is `on_failure`
+func (r *Runner) logBufferSize() int {
+	switch {
+	case r.svc.LogBufferSize > 0:
+		return r.svc.LogBufferSize
+	case r.defaultLogBufferSize > 0:
+		return r.defaultLogBufferSize
+	default:
+		return defaultLogBufferSize
+	}
+}
+
+// logHistorySize returns the amount of lines kept in the history
+func (r *Runner) logHistorySize() int {
+	switch {
+	case r.svc.LogHistorySize > 0:
+		return r.svc.LogHistorySize
+	case r.defaultLogHistorySize > 0:
+		return r.defaultLogHistorySize
+	default:
+		return defaultLogHistorySize
+	}
+}
+
+// logLine handles a line logged by the container on the given stream
+func (r *Runner) logLine(stream LogStream, line string) {
+	r.writeArtifact(stream, line)
+	e := r.decodeLogEntry(stream, line)
+	r.logHistory.add(e)
+	switch r.logMode() {
+	case LogModeAlways:
+		r.testLog(e)
+	case LogModeOnFailure:
+		r.logBuffer.add(e)
+	}
+}
+
+// testLog sends the entry logged by the container to the test logs
+func (r *Runner) testLog(e LogEntry) {
+	if e.Line != "" {
+		r.log.Logf("Logs from %s: %s", r.colorize(fmt.Sprintf("Container %q %s", r.name, strings.ToUpper(string(e.Stream)))), r.formatLogEntry(e))
+	}
+}
+
+// flushLogBuffer sends the buffered logs to the test logs if the test has failed, and empties the buffer
+func (r *Runner) flushLogBuffer() {
+	entries, dropped := r.logBuffer.drain()
+	if !failed(r.log) || len(entries) == 0 {
+		return
+	}
+	r.log.Logf("Test failed, showing the last %d lines of logs from Container %q (%d previous lines were dropped)", len(entries), r.name, dropped)
+	for _, e := range entries {
+		r.testLog(e)
+	}
+}
+
+// LogEntry is a line logged by a container
+type LogEntry struct {
+	// Stream is the stream the line was logged on
+	Stream LogStream
+	// Line is the line logged, as is
+	Line string
+
+	// Fields are the fields of the line decoded according to the `log_format` of the service, nil if it wasn't decoded
+	Fields map[string]any
+	// Level and Message are the values of the well-known level and message fields, if the line was decoded and they're strings
+	Level   string
+	Message string
+}
+
+// logBuffer is a ring buffer keeping the last log entries of a container.
+// It's safe for concurrent use, and it's a no-op if nil.
+type logBuffer struct {
+	mtx     sync.Mutex
+	entries []LogEntry
+	next    int
+	full    bool
+	dropped int
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{entries: make([]LogEntry, size)}
+}
+
+func (b *logBuffer) add(e LogEntry) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.full {
+		b.dropped++
+	}
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	b.full = b.full || b.next == 0
+}
+
+// drain returns the buffered entries in order and the amount of the entries dropped because the buffer was full,
+// emptying the buffer.
+func (b *logBuffer) drain() (entries []LogEntry, dropped int) {
+	if b == nil {
+		return nil, 0
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.full {
+		entries = append(entries, b.entries[b.next:]...)
+	}
+	entries = append(entries, b.entries[:b.next]...)
+	dropped = b.dropped
+
+	b.next, b.full, b.dropped = 0, false, 0
+	return entries, dropped
+}
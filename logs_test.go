@@ -0,0 +1,182 @@
+package aceptadora
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLogger is a Logger keeping the lines logged, which reports the test as failed once fail is called
+type recordingLogger struct {
+	mtx    sync.Mutex
+	lines  []string
+	failed bool
+}
+
+func (l *recordingLogger) Logf(format string, args ...any) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Failed() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.failed
+}
+
+func (l *recordingLogger) fail() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.failed = true
+}
+
+// containing returns the lines logged containing s
+func (l *recordingLogger) containing(s string) []string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	var found []string
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+func TestLogBuffer(t *testing.T) {
+	entries := func(from, to int) []LogEntry {
+		var entries []LogEntry
+		for i := from; i <= to; i++ {
+			entries = append(entries, LogEntry{Stream: Stdout, Line: fmt.Sprintf("line %d", i)})
+		}
+		return entries
+	}
+
+	for _, tc := range []struct {
+		name            string
+		added           int
+		expected        []LogEntry
+		expectedDropped int
+	}{
+		{name: "empty"},
+		{name: "not full", added: 2, expected: entries(1, 2)},
+		{name: "exactly full", added: 3, expected: entries(1, 3)},
+		{name: "wrapped around once", added: 4, expected: entries(2, 4), expectedDropped: 1},
+		{name: "wrapped around several times", added: 10, expected: entries(8, 10), expectedDropped: 7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newLogBuffer(3)
+			for _, e := range entries(1, tc.added) {
+				b.add(e)
+			}
+
+			got, dropped := b.drain()
+			assert.Equal(t, tc.expected, got)
+			assert.Equal(t, tc.expectedDropped, dropped)
+
+			got, dropped = b.drain()
+			assert.Empty(t, got, "drained buffer is empty")
+			assert.Zero(t, dropped)
+
+			b.add(LogEntry{Line: "after drain"})
+			got, _ = b.drain()
+			assert.Equal(t, []LogEntry{{Line: "after drain"}}, got)
+		})
+	}
+}
+
+func TestLogBuffer_Nil(t *testing.T) {
+	var b *logBuffer
+	b.add(LogEntry{Line: "ignored"})
+	entries, dropped := b.drain()
+	assert.Nil(t, entries)
+	assert.Zero(t, dropped)
+}
+
+func TestCore_LogMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode         LogMode
+		testFailed   bool
+		expectLogged bool
+	}{
+		{mode: LogModeAlways, expectLogged: true},
+		{mode: LogModeAlways, testFailed: true, expectLogged: true},
+		{mode: LogModeOnFailure},
+		{mode: LogModeOnFailure, testFailed: true, expectLogged: true},
+		{mode: LogModeNever},
+		{mode: LogModeNever, testFailed: true},
+	} {
+		t.Run(fmt.Sprintf("%s when failed=%t", tc.mode, tc.testFailed), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			docker := newFakeDocker()
+			docker.startLogs = func(name string) []LogEntry {
+				return []LogEntry{{Stream: Stdout, Line: "first line"}, {Stream: Stderr, Line: "second line"}}
+			}
+			log := &recordingLogger{}
+			core, err := NewCore(log, newFakePuller(), newTestConfigWithYAML(t, fmt.Sprintf(`services:
+  api:
+    image: docker.io/library/api:latest
+    log_mode: %s
+`, tc.mode)))
+			require.NoError(t, err)
+			core.newClient = docker.client
+
+			require.NoError(t, core.Run(ctx, "api"))
+			_, err = core.WaitForLog(ctx, "api", "second line")
+			require.NoError(t, err)
+			if tc.testFailed {
+				log.fail()
+			}
+			require.NoError(t, core.StopAll(ctx))
+
+			logged := log.containing("Logs from")
+			if !tc.expectLogged {
+				assert.Empty(t, logged)
+				assert.Empty(t, log.containing("Test failed"))
+				return
+			}
+			require.Len(t, logged, 2)
+			assert.Contains(t, logged[0], "STDOUT")
+			assert.Contains(t, logged[0], "first line")
+			assert.Contains(t, logged[1], "STDERR")
+			assert.Contains(t, logged[1], "second line")
+			if tc.mode == LogModeOnFailure {
+				assert.Equal(t, []string{`Test failed, showing the last 2 lines of logs from Container "api" (0 previous lines were dropped)`}, log.containing("Test failed"))
+			}
+		})
+	}
+}
+
+// cleanupCountingTB counts the cleanup functions registered
+type cleanupCountingTB struct {
+	testing.TB
+	cleanups int
+}
+
+func (tb *cleanupCountingTB) Cleanup(f func()) {
+	tb.cleanups++
+	tb.TB.Cleanup(f)
+}
+
+func TestRunner_RegistersTheLogBufferFlushOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tb := &cleanupCountingTB{TB: t}
+	r := NewRunner(tb, "api", Service{Image: "docker.io/library/api:latest", LogMode: LogModeOnFailure}, newFakePuller())
+	r.newClient = newFakeDocker().client
+	for i := 0; i < 3; i++ {
+		r.Start(ctx)
+		require.NoError(t, r.Stop(ctx))
+	}
+	assert.Equal(t, 1, tb.cleanups)
+}
@@ -0,0 +1,168 @@
+package aceptadora
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// partitionNetworkPrefix is the prefix of the networks created for each group of a partition
+const partitionNetworkPrefix = "aceptadora-partition"
+
+// Disconnect disconnects a running service from the provided network (prefixed by the session if there's one),
+// or from its own network if it's empty, so it can't reach the other services on that network, nor be reached by them.
+// The tester can still reach the ports published by the service.
+func (c *Core) Disconnect(ctx context.Context, name, network string) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+	network = c.networkName(runner, network)
+	if err := runner.disconnect(ctx, network); err != nil {
+		return fmt.Errorf("can't disconnect %q from network %q: %w", name, network, err)
+	}
+	return nil
+}
+
+// Reconnect connects again a running service to the provided network (prefixed by the session if there's one),
+// or to its own network if it's empty.
+func (c *Core) Reconnect(ctx context.Context, name, network string) error {
+	runner, err := c.running(name)
+	if err != nil {
+		return err
+	}
+	network = c.networkName(runner, network)
+	if err := runner.connect(ctx, network); err != nil {
+		return fmt.Errorf("can't connect %q to network %q: %w", name, network, err)
+	}
+	return nil
+}
+
+// networkName returns the name of the provided network in the session, or the network of the runner if it's empty
+func (c *Core) networkName(runner *Runner, network string) string {
+	if network == "" {
+		return runner.network()
+	}
+	return sessionName(c.cfg.SessionID, network)
+}
+
+// Partition isolates the services of groupA from the services of groupB.
+// Each group is moved from its own network to a network created for the group,
+// so the services of the same group can still reach each other, while they can't reach the services of the other group.
+// Services that are not part of any group can't reach the partitioned services either.
+// The tester can still reach the ports published by all of them.
+// Both groups are checked before moving any service: they can't be empty nor share services,
+// and all their services should be running and not partitioned yet.
+// If a service can't be moved, the ones moved before it stay partitioned until Heal is called.
+// Use Heal to restore the networks of all the partitioned services.
+func (c *Core) Partition(ctx context.Context, groupA, groupB []string) error {
+	c.partitionMtx.Lock()
+	defer c.partitionMtx.Unlock()
+
+	runners, err := c.partitionRunners(groupA, groupB)
+	if err != nil {
+		return fmt.Errorf("can't partition %q from %q: %w", groupA, groupB, err)
+	}
+
+	c.mtx.Lock()
+	c.partitions++
+	id := c.partitions
+	c.mtx.Unlock()
+
+	for i, group := range [][]string{groupA, groupB} {
+		network := sessionName(c.cfg.SessionID, fmt.Sprintf("%s-%d-%c", partitionNetworkPrefix, id, 'a'+i))
+		for _, name := range group {
+			if err := c.partition(ctx, name, runners[name], network); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// partitionRunners validates the groups of a partition, returning the runners of their services
+func (c *Core) partitionRunners(groupA, groupB []string) (map[string]*Runner, error) {
+	if len(groupA) == 0 || len(groupB) == 0 {
+		return nil, errors.New("both groups should have services")
+	}
+
+	runners := map[string]*Runner{}
+	for _, name := range append(slices.Clone(groupA), groupB...) {
+		if _, ok := runners[name]; ok {
+			return nil, fmt.Errorf("service %q is listed more than once", name)
+		}
+		runner, err := c.running(name)
+		if err != nil {
+			return nil, err
+		}
+		c.mtx.Lock()
+		current, partitioned := c.partitioned[name]
+		c.mtx.Unlock()
+		if partitioned {
+			return nil, fmt.Errorf("service %q is already partitioned in network %q", name, current)
+		}
+		runners[name] = runner
+	}
+	return runners, nil
+}
+
+// partition moves the service from its network to the provided one, recording it only once it's been moved.
+// It should be called holding the partitionMtx.
+func (c *Core) partition(ctx context.Context, name string, runner *Runner, network string) error {
+	// the network is created when the first service of the group is connected to it
+	if _, ok := c.partitionNetworks[network]; !ok {
+		c.partitionNetworks[network] = runner
+	}
+	if err := runner.connect(ctx, network); err != nil {
+		return fmt.Errorf("can't connect %q to network %q: %w", name, network, err)
+	}
+	if err := runner.disconnect(ctx, runner.network()); err != nil {
+		// leave it as it was, so it doesn't need to be healed
+		if undoErr := runner.disconnect(ctx, network); undoErr != nil {
+			err = errors.Join(err, fmt.Errorf("can't disconnect it from network %q: %w", network, undoErr))
+		}
+		return fmt.Errorf("can't disconnect %q from network %q: %w", name, runner.network(), err)
+	}
+
+	c.mtx.Lock()
+	c.partitioned[name] = network
+	c.mtx.Unlock()
+	c.log.Logf("Service %q partitioned into network %q", name, network)
+	return nil
+}
+
+// Heal restores the networks of all the services partitioned by Partition,
+// and removes the networks created for their groups.
+func (c *Core) Heal(ctx context.Context) error {
+	c.partitionMtx.Lock()
+	defer c.partitionMtx.Unlock()
+
+	c.mtx.Lock()
+	partitioned := c.partitioned
+	c.partitioned = map[string]string{}
+	c.mtx.Unlock()
+	networks := c.partitionNetworks
+	c.partitionNetworks = map[string]*Runner{}
+
+	var errs []error
+	for name, network := range partitioned {
+		runner, _ := c.runner(name)
+		if err := runner.connect(ctx, runner.network()); err != nil {
+			errs = append(errs, fmt.Errorf("can't connect %q to network %q: %w", name, runner.network(), err))
+		}
+		if err := runner.disconnect(ctx, network); err != nil {
+			errs = append(errs, fmt.Errorf("can't disconnect %q from network %q: %w", name, network, err))
+		}
+	}
+
+	for network, runner := range networks {
+		// it might not have been created if connecting the first service of its group failed
+		if err := runner.client.NetworkRemove(ctx, network); err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("can't remove network %q: %w", network, err))
+		}
+	}
+	return errors.Join(errs...)
+}
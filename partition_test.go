@@ -0,0 +1,122 @@
+package aceptadora
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCore_PartitionAndHeal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	core := newTestCore(t, docker)
+	require.NoError(t, core.RunAll(ctx, "api", "cache", "db"))
+
+	require.NoError(t, core.Partition(ctx, []string{"api", "cache"}, []string{"db"}))
+	assert.Equal(t, []string{"aceptadora-partition-1-a"}, docker.networksOf("api"))
+	assert.Equal(t, []string{"aceptadora-partition-1-a"}, docker.networksOf("cache"))
+	assert.Equal(t, []string{"aceptadora-partition-1-b"}, docker.networksOf("db"))
+
+	require.NoError(t, core.Heal(ctx))
+	for _, name := range []string{"api", "cache", "db"} {
+		assert.Equal(t, []string{DefaultNetwork}, docker.networksOf(name), name)
+	}
+	assert.Empty(t, docker.partitionNetworks())
+	require.NoError(t, core.StopAll(ctx))
+}
+
+func TestCore_PartitionValidatesTheGroups(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		groupA    []string
+		groupB    []string
+		expectErr string
+	}{
+		{name: "empty group", groupA: []string{"api"}, expectErr: "both groups should have services"},
+		{name: "same service in both groups", groupA: []string{"api"}, groupB: []string{"db", "api"}, expectErr: `service "api" is listed more than once`},
+		{name: "unknown service", groupA: []string{"api"}, groupB: []string{"db", "unknown"}, expectErr: ErrServiceNotFound.Error()},
+		{name: "stopped service", groupA: []string{"api"}, groupB: []string{"db", "cache"}, expectErr: ErrServiceNotRunning.Error()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			docker := newFakeDocker()
+			core := newTestCore(t, docker)
+			require.NoError(t, core.RunAll(ctx, "api", "cache", "db"))
+			require.NoError(t, core.Stop(ctx, "cache"))
+
+			err := core.Partition(ctx, tc.groupA, tc.groupB)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectErr)
+
+			// no service was moved, so there's nothing to heal
+			for _, name := range []string{"api", "db"} {
+				assert.Equal(t, []string{DefaultNetwork}, docker.networksOf(name), name)
+			}
+			require.NoError(t, core.Heal(ctx))
+			require.NoError(t, core.StopAll(ctx))
+		})
+	}
+}
+
+func TestCore_PartitionFailingPartway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	core := newTestCore(t, docker)
+	require.NoError(t, core.RunAll(ctx, "api", "cache", "db"))
+
+	docker.onConnect = func(network, name string) error {
+		if name == "db" {
+			return errors.New("network unavailable")
+		}
+		return nil
+	}
+	err := core.Partition(ctx, []string{"api", "cache"}, []string{"db"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "network unavailable")
+	assert.Equal(t, []string{"aceptadora-partition-1-a"}, docker.networksOf("api"))
+	assert.Equal(t, []string{DefaultNetwork}, docker.networksOf("db"))
+
+	// only the services that were moved are healed
+	docker.onConnect = nil
+	require.NoError(t, core.Heal(ctx))
+	for _, name := range []string{"api", "cache", "db"} {
+		assert.Equal(t, []string{DefaultNetwork}, docker.networksOf(name), name)
+	}
+	assert.Empty(t, docker.partitionNetworks(), "the networks of both groups are removed")
+	require.NoError(t, core.StopAll(ctx))
+}
+
+func TestCore_PartitionFailingToConnectTheFirstServiceOfAGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	core := newTestCore(t, docker)
+	require.NoError(t, core.RunAll(ctx, "api", "db"))
+
+	docker.onConnect = func(network, name string) error {
+		return errors.New("network unavailable")
+	}
+	err := core.Partition(ctx, []string{"api"}, []string{"db"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "network unavailable")
+	assert.Equal(t, []string{"aceptadora-partition-1-a"}, docker.partitionNetworks(), "the network was created before failing")
+
+	docker.onConnect = nil
+	require.NoError(t, core.Heal(ctx))
+	assert.Empty(t, docker.partitionNetworks(), "the network created is removed although no service was moved")
+	for _, name := range []string{"api", "db"} {
+		assert.Equal(t, []string{DefaultNetwork}, docker.networksOf(name), name)
+	}
+	require.NoError(t, core.StopAll(ctx))
+}
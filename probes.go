@@ -0,0 +1,292 @@
+package aceptadora
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	defaultProbeTimeout  = time.Minute
+	defaultProbeInterval = 50 * time.Millisecond
+)
+
+// WaitFor defines the readiness probes of a service.
+// Runner.Start will only return once all the probes defined have succeeded, in the order they're defined here.
+type WaitFor struct {
+	TCP         *TCPProbe         `yaml:"tcp"`
+	HTTP        *HTTPProbe        `yaml:"http"`
+	Log         *LogProbe         `yaml:"log"`
+	Exec        *ExecProbe        `yaml:"exec"`
+	Healthcheck *HealthcheckProbe `yaml:"healthcheck"`
+}
+
+// ProbeTiming configures how long and how often a probe is checked.
+type ProbeTiming struct {
+	// Timeout is the time the probe has to succeed, one minute by default.
+	Timeout time.Duration `yaml:"timeout"`
+	// Interval is the time waited between failed checks, 50ms by default.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// TCPProbe succeeds once a TCP connection to the given container port is accepted through its published host port.
+type TCPProbe struct {
+	ProbeTiming `yaml:",inline"`
+	// Port is the container port, like `6379` or `6379/tcp`
+	Port string `yaml:"port"`
+}
+
+// HTTPProbe succeeds once a GET request to the given path of the container port's published host port
+// responds with the expected status.
+type HTTPProbe struct {
+	ProbeTiming `yaml:",inline"`
+	// Port is the container port, like `8080` or `8080/tcp`
+	Port string `yaml:"port"`
+	// Path is the requested path, `/` by default.
+	Path string `yaml:"path"`
+	// Status is the expected status code, 200 by default.
+	Status int `yaml:"status"`
+}
+
+// LogProbe succeeds once a line on the container's stdout or stderr matches the given regexp.
+type LogProbe struct {
+	ProbeTiming `yaml:",inline"`
+	Regexp      string `yaml:"regexp"`
+}
+
+// ExecProbe succeeds once the given command executed inside of the container exits with the expected exit code.
+type ExecProbe struct {
+	ProbeTiming `yaml:",inline"`
+	Command     []string `yaml:"command"`
+	// ExitCode is the expected exit code, zero by default.
+	ExitCode int `yaml:"exit_code"`
+}
+
+// HealthcheckProbe succeeds once the docker HEALTHCHECK of the container reports it as healthy.
+// Since it has no other fields, it can be enabled with an empty map: `healthcheck: {}`
+type HealthcheckProbe struct {
+	ProbeTiming `yaml:",inline"`
+}
+
+type probe interface {
+	fmt.Stringer
+	timing() ProbeTiming
+	check(ctx context.Context, r *Runner) error
+}
+
+// probes returns the probes defined, in the order they should be checked.
+func (w WaitFor) probes() []probe {
+	var probes []probe
+	if w.TCP != nil {
+		probes = append(probes, w.TCP)
+	}
+	if w.HTTP != nil {
+		probes = append(probes, w.HTTP)
+	}
+	if w.Log != nil {
+		probes = append(probes, w.Log)
+	}
+	if w.Exec != nil {
+		probes = append(probes, w.Exec)
+	}
+	if w.Healthcheck != nil {
+		probes = append(probes, w.Healthcheck)
+	}
+	return probes
+}
+
+// validate checks that the probes are well defined, so we don't wait for a probe that can't ever succeed.
+// The ports of the tcp and http probes should be defined in the provided `ports` of the service.
+func (w WaitFor) validate(ports []string) error {
+	exposed, _, err := nat.ParsePortSpecs(ports)
+	if err != nil {
+		return fmt.Errorf("can't parse port specs: %w", err)
+	}
+	if w.TCP != nil {
+		if err := validateProbePort("tcp", w.TCP.Port, exposed); err != nil {
+			return err
+		}
+	}
+	if w.HTTP != nil {
+		if err := validateProbePort("http", w.HTTP.Port, exposed); err != nil {
+			return err
+		}
+	}
+	if w.Log != nil {
+		if _, err := regexp.Compile(w.Log.Regexp); err != nil {
+			return fmt.Errorf("log probe has an invalid regexp: %w", err)
+		}
+	}
+	if w.Exec != nil && len(w.Exec.Command) == 0 {
+		return fmt.Errorf("exec probe needs a command")
+	}
+	return nil
+}
+
+// validateProbePort checks that the port of the probe is one of the ports of the service
+func validateProbePort(probe, port string, exposed map[nat.Port]struct{}) error {
+	if port == "" {
+		return fmt.Errorf("%s probe needs a port", probe)
+	}
+	if _, ok := exposed[nat.Port(normalizePort(port))]; !ok {
+		return fmt.Errorf("%s probe port %s is not one of the ports of the service", probe, port)
+	}
+	return nil
+}
+
+func (t ProbeTiming) timing() ProbeTiming {
+	if t.Timeout <= 0 {
+		t.Timeout = defaultProbeTimeout
+	}
+	if t.Interval <= 0 {
+		t.Interval = defaultProbeInterval
+	}
+	return t
+}
+
+func (p *TCPProbe) String() string {
+	return fmt.Sprintf("tcp probe on port %s", p.Port)
+}
+
+func (p *TCPProbe) check(ctx context.Context, r *Runner) error {
+	addr, err := r.endpoint(p.Port)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *HTTPProbe) String() string {
+	return fmt.Sprintf("http probe on port %s path %s expecting status %d", p.Port, p.path(), p.status())
+}
+
+func (p *HTTPProbe) check(ctx context.Context, r *Runner) error {
+	addr, err := r.endpoint(p.Port)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+p.path(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != p.status() {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *HTTPProbe) path() string {
+	if p.Path == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(p.Path, "/") {
+		return "/" + p.Path
+	}
+	return p.Path
+}
+
+func (p *HTTPProbe) status() int {
+	if p.Status == 0 {
+		return http.StatusOK
+	}
+	return p.Status
+}
+
+func (p *LogProbe) String() string {
+	return fmt.Sprintf("log probe matching %q", p.Regexp)
+}
+
+// check waits until a line logged by the current run of the container matches, or the context is done.
+// The lines logged by the previous runs are not matched, as they'd make a restarted container look ready.
+func (p *LogProbe) check(ctx context.Context, r *Runner) error {
+	match, err := logMatcher(p.Regexp, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := r.logHistory.waitForSince(ctx, r.logHistoryStart, match); err != nil {
+		return fmt.Errorf("no line matched: %w", err)
+	}
+	return nil
+}
+
+func (p *ExecProbe) String() string {
+	return fmt.Sprintf("exec probe %q expecting exit code %d", p.Command, p.ExitCode)
+}
+
+func (p *ExecProbe) check(ctx context.Context, r *Runner) error {
+	res, err := r.exec(ctx, ExecConfig{Cmd: p.Command})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != p.ExitCode {
+		return fmt.Errorf("got exit code %d, stdout: %q, stderr: %q", res.ExitCode, res.Stdout, res.Stderr)
+	}
+	return nil
+}
+
+func (p *HealthcheckProbe) String() string {
+	return "healthcheck probe"
+}
+
+func (p *HealthcheckProbe) check(ctx context.Context, r *Runner) error {
+	status, err := r.healthStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if status != types.Healthy {
+		return fmt.Errorf("health status is %q", status)
+	}
+	return nil
+}
+
+// waitUntilReady checks all the probes defined for the service, one after another.
+func (r *Runner) waitUntilReady(ctx context.Context) error {
+	for _, p := range r.svc.WaitFor.probes() {
+		if err := r.waitForProbe(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForProbe checks the probe every interval until it succeeds or its timeout is reached.
+func (r *Runner) waitForProbe(ctx context.Context, p probe) error {
+	timing := p.timing()
+	ctx, cancel := context.WithTimeout(ctx, timing.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(timing.Interval)
+	defer ticker.Stop()
+	for {
+		err := p.check(ctx, r)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%s never succeeded within %s, last error: %w", p, timing.Timeout, err)
+		}
+	}
+}
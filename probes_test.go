@@ -0,0 +1,108 @@
+package aceptadora
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitFor_Validate(t *testing.T) {
+	ports := []string{"6379", "127.0.0.1:8080:80", "5353/udp"}
+
+	for _, tc := range []struct {
+		name        string
+		waitFor     WaitFor
+		ports       []string
+		expectedErr string
+	}{
+		{name: "no probes"},
+		{name: "tcp probe on a port", waitFor: WaitFor{TCP: &TCPProbe{Port: "6379"}}, ports: ports},
+		{name: "tcp probe on a port with protocol", waitFor: WaitFor{TCP: &TCPProbe{Port: "6379/tcp"}}, ports: ports},
+		{name: "http probe on a container port bound to another host port", waitFor: WaitFor{HTTP: &HTTPProbe{Port: "80"}}, ports: ports},
+		{name: "tcp probe on an udp port", waitFor: WaitFor{TCP: &TCPProbe{Port: "5353/udp"}}, ports: ports},
+		{
+			name:        "tcp probe without port",
+			waitFor:     WaitFor{TCP: &TCPProbe{}},
+			ports:       ports,
+			expectedErr: "tcp probe needs a port",
+		},
+		{
+			name:        "tcp probe on a port that isn't defined",
+			waitFor:     WaitFor{TCP: &TCPProbe{Port: "6380"}},
+			ports:       ports,
+			expectedErr: "tcp probe port 6380 is not one of the ports of the service",
+		},
+		{
+			name:        "http probe on the host port",
+			waitFor:     WaitFor{HTTP: &HTTPProbe{Port: "8080"}},
+			ports:       ports,
+			expectedErr: "http probe port 8080 is not one of the ports of the service",
+		},
+		{
+			name:        "http probe on a service without ports",
+			waitFor:     WaitFor{HTTP: &HTTPProbe{Port: "80"}},
+			expectedErr: "http probe port 80 is not one of the ports of the service",
+		},
+		{
+			name:        "log probe with an invalid regexp",
+			waitFor:     WaitFor{Log: &LogProbe{Regexp: "("}},
+			expectedErr: "log probe has an invalid regexp",
+		},
+		{
+			name:        "exec probe without command",
+			waitFor:     WaitFor{Exec: &ExecProbe{}},
+			expectedErr: "exec probe needs a command",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.waitFor.validate(tc.ports)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestLogProbe_DoesntMatchTheLinesOfThePreviousRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mtx sync.Mutex
+	starts := 0
+	docker := newFakeDocker()
+	docker.startLogs = func(name string) []LogEntry {
+		mtx.Lock()
+		defer mtx.Unlock()
+		starts++
+		if starts == 1 {
+			return []LogEntry{{Stream: Stdout, Line: "ready to accept connections"}}
+		}
+		// the restarted container never gets ready
+		return []LogEntry{{Stream: Stdout, Line: "recovering data"}}
+	}
+
+	core, err := NewCore(t, newFakePuller(), newTestConfigWithYAML(t, `services:
+  db:
+    image: docker.io/library/db:latest
+    wait_for:
+      log:
+        regexp: ready to accept connections
+        timeout: 200ms
+`))
+	require.NoError(t, err)
+	core.newClient = docker.client
+
+	require.NoError(t, core.Run(ctx, "db"))
+	err = core.Restart(ctx, "db")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `log probe matching "ready to accept connections" never succeeded`)
+	require.NoError(t, core.StopAll(ctx))
+}
@@ -0,0 +1,478 @@
+package aceptadora
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	proxyDialTimeout = 5 * time.Second
+	proxyBufferSize  = 32 * 1024
+	// proxyBandwidthSlice is the time slice in which the bandwidth limit is applied
+	proxyBandwidthSlice = 100 * time.Millisecond
+)
+
+// Toxics defines the faults a Proxy injects into the connections it proxies.
+// The zero value doesn't inject any fault.
+type Toxics struct {
+	// Latency is added before forwarding each chunk of data, in both directions.
+	Latency time.Duration
+	// Jitter randomly adds or subtracts up to this duration to the Latency.
+	Jitter time.Duration
+	// Bandwidth limits the bytes per second forwarded in each direction of each connection, unlimited if zero.
+	Bandwidth int
+	// ResetPeer resets (TCP RST) the existing connections when set, and the new ones as soon as they're accepted.
+	ResetPeer bool
+	// Timeout stops forwarding any data, dropping it, like a peer that stopped responding.
+	Timeout bool
+	// TimeoutClose closes the connections once this duration has elapsed since Timeout was set.
+	// If zero, connections are kept open.
+	TimeoutClose time.Duration
+	// HalfOpen stops propagating the closing of the connections: when one side closes it, the other side is kept open
+	// but no more data is forwarded to it, like when a peer disappears without closing its connections.
+	HalfOpen bool
+}
+
+// ConnStats are the statistics of a connection proxied by a Proxy
+type ConnStats struct {
+	ID     int
+	Client string
+	Opened time.Time
+	// Closed is zero while the connection is open
+	Closed time.Time
+	// BytesSent is the amount of bytes forwarded from the client to the upstream
+	BytesSent int64
+	// BytesReceived is the amount of bytes forwarded from the upstream to the client
+	BytesReceived int64
+}
+
+// Proxy is a TCP proxy running in the tester's process, which forwards the connections it accepts to an upstream address.
+// Faults can be injected into the proxied connections at runtime using SetToxics.
+type Proxy struct {
+	log      Logger
+	name     string
+	upstream func() (string, error)
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mtx    sync.Mutex
+	toxics Toxics
+	conns  []*proxyConn
+	closed bool
+}
+
+// NewProxy starts a Proxy listening on listenAddr (like `127.0.0.1:0` for a random port) that forwards to upstream.
+func NewProxy(t testing.TB, name, listenAddr, upstream string) *Proxy {
+	p, err := newProxy(t, name, listenAddr, func() (string, error) { return upstream, nil })
+	require.NoError(t, err, "Can't start proxy %q: %s", name, err)
+	return p
+}
+
+// newProxy starts a Proxy that resolves the upstream address for each connection, as it might change while the proxy runs.
+func newProxy(log Logger, name, listenAddr string, upstream func() (string, error)) (*Proxy, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		log:      log,
+		name:     name,
+		upstream: upstream,
+		listener: listener,
+	}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Port returns the port the proxy is listening on
+func (p *Proxy) Port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+// SetToxics replaces the faults injected by the proxy, both into the existing and into the new connections.
+// Use SetToxics(Toxics{}) to stop injecting faults.
+func (p *Proxy) SetToxics(toxics Toxics) {
+	p.mtx.Lock()
+	p.toxics = toxics
+	conns := append([]*proxyConn(nil), p.conns...)
+	p.mtx.Unlock()
+
+	for _, c := range conns {
+		c.applyToxics(toxics)
+	}
+}
+
+// Toxics returns the faults currently injected by the proxy
+func (p *Proxy) Toxics() Toxics {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.toxics
+}
+
+// Stats returns the statistics of all the connections accepted by the proxy, including the closed ones.
+func (p *Proxy) Stats() []ConnStats {
+	p.mtx.Lock()
+	conns := append([]*proxyConn(nil), p.conns...)
+	p.mtx.Unlock()
+
+	stats := make([]ConnStats, 0, len(conns))
+	for _, c := range conns {
+		stats = append(stats, c.stats())
+	}
+	return stats
+}
+
+// Close stops accepting connections and closes the existing ones.
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+
+	p.mtx.Lock()
+	p.closed = true
+	conns := append([]*proxyConn(nil), p.conns...)
+	p.mtx.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		downstream, err := p.listener.Accept()
+		if err != nil {
+			// listener was closed
+			return
+		}
+		p.wg.Add(1)
+		go p.handle(downstream)
+	}
+}
+
+func (p *Proxy) handle(downstream net.Conn) {
+	defer p.wg.Done()
+
+	p.mtx.Lock()
+	if p.closed {
+		// accepted while the proxy was being closed
+		p.mtx.Unlock()
+		downstream.Close()
+		return
+	}
+	c := &proxyConn{
+		proxy:      p,
+		id:         len(p.conns) + 1,
+		client:     downstream.RemoteAddr().String(),
+		opened:     time.Now(),
+		downstream: downstream,
+	}
+	p.conns = append(p.conns, c)
+	toxics := p.toxics
+	p.mtx.Unlock()
+	defer c.close()
+
+	if toxics.ResetPeer {
+		c.reset()
+		return
+	}
+
+	addr, err := p.upstream()
+	if err != nil {
+		p.log.Logf("Proxy %q can't resolve its upstream: %s", p.name, err)
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", addr, proxyDialTimeout)
+	if err != nil {
+		p.log.Logf("Proxy %q can't connect to its upstream %s: %s", p.name, addr, err)
+		return
+	}
+	if !c.setUpstream(upstream) {
+		// connection was closed meanwhile
+		upstream.Close()
+		return
+	}
+	c.applyToxics(toxics)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.pipe(upstream, downstream, &c.sent)
+	}()
+	go func() {
+		defer wg.Done()
+		c.pipe(downstream, upstream, &c.received)
+	}()
+	wg.Wait()
+}
+
+type proxyConn struct {
+	proxy      *Proxy
+	id         int
+	client     string
+	opened     time.Time
+	downstream net.Conn
+
+	sent     atomic.Int64
+	received atomic.Int64
+
+	mtx          sync.Mutex
+	upstream     net.Conn
+	closed       time.Time
+	timeoutTimer *time.Timer
+}
+
+func (c *proxyConn) stats() ConnStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return ConnStats{
+		ID:            c.id,
+		Client:        c.client,
+		Opened:        c.opened,
+		Closed:        c.closed,
+		BytesSent:     c.sent.Load(),
+		BytesReceived: c.received.Load(),
+	}
+}
+
+// setUpstream sets the upstream connection, returning false if the connection was already closed.
+func (c *proxyConn) setUpstream(upstream net.Conn) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.closed.IsZero() {
+		return false
+	}
+	c.upstream = upstream
+	return true
+}
+
+func (c *proxyConn) applyToxics(toxics Toxics) {
+	if toxics.ResetPeer {
+		c.reset()
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.timeoutTimer != nil {
+		c.timeoutTimer.Stop()
+		c.timeoutTimer = nil
+	}
+	if toxics.Timeout && toxics.TimeoutClose > 0 && c.closed.IsZero() {
+		c.timeoutTimer = time.AfterFunc(toxics.TimeoutClose, c.close)
+	}
+}
+
+// pipe forwards the data read from src to dst, applying the toxics of the proxy, and adding the bytes forwarded to the counter.
+func (c *proxyConn) pipe(dst, src net.Conn, counter *atomic.Int64) {
+	buf := make([]byte, proxyBufferSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			toxics := c.proxy.Toxics()
+			// with Timeout the data is just dropped
+			if !toxics.Timeout {
+				written, werr := toxics.write(dst, buf[:n])
+				counter.Add(int64(written))
+				if werr != nil && !toxics.HalfOpen {
+					c.close()
+					return
+				}
+			}
+		}
+		if err != nil {
+			if c.proxy.Toxics().HalfOpen {
+				// the other side isn't told that this one was closed
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+					_ = cw.CloseWrite()
+					return
+				}
+			}
+			c.close()
+			return
+		}
+	}
+}
+
+// write writes the data to the connection applying the latency and the bandwidth limit.
+func (t Toxics) write(conn net.Conn, data []byte) (int, error) {
+	if delay := t.delay(); delay > 0 {
+		time.Sleep(delay)
+	}
+	if t.Bandwidth <= 0 {
+		return conn.Write(data)
+	}
+
+	chunkSize := max(1, int(int64(t.Bandwidth)*int64(proxyBandwidthSlice)/int64(time.Second)))
+	written := 0
+	for written < len(data) {
+		chunk := data[written:min(len(data), written+chunkSize)]
+		n, err := conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(t.Bandwidth))
+	}
+	return written, nil
+}
+
+func (t Toxics) delay() time.Duration {
+	if t.Jitter <= 0 {
+		return t.Latency
+	}
+	return t.Latency + time.Duration(rand.Int63n(int64(2*t.Jitter)+1)) - t.Jitter
+}
+
+// reset closes the connections sending a TCP RST instead of a FIN
+func (c *proxyConn) reset() {
+	c.mtx.Lock()
+	for _, conn := range []net.Conn{c.downstream, c.upstream} {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			_ = tcp.SetLinger(0)
+		}
+	}
+	c.mtx.Unlock()
+	c.close()
+}
+
+func (c *proxyConn) close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.closed.IsZero() {
+		return
+	}
+	c.closed = time.Now()
+	if c.timeoutTimer != nil {
+		c.timeoutTimer.Stop()
+	}
+	c.downstream.Close()
+	if c.upstream != nil {
+		c.upstream.Close()
+	}
+}
+
+// Proxy returns the proxy through which the tester reaches the given container port of a service,
+// which should be listed in the `proxied_ports` of the service.
+// The proxy keeps working if the service is restarted, even if its port is published on a different host port.
+func (c *Core) Proxy(name, port string) (*Proxy, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	p, ok := c.proxies[name+" "+normalizePort(port)]
+	if !ok {
+		return nil, fmt.Errorf("there's no proxy for port %s of service %q, is it listed in its proxied_ports?", port, name)
+	}
+	return p, nil
+}
+
+// ProxyTester starts a proxy through which the services reach the given port of the tester,
+// listening on a random port of TESTER_ADDRESS, and sets the env var provided to that port.
+// Env vars are expanded in env files when the containers are created,
+// so they can reach the tester through the proxy at `${TESTER_ADDRESS}:${envVar}`.
+// If TESTER_ADDRESS isn't an address of this host (like when it's the address of a NAT gateway),
+// the proxy listens on all the interfaces instead.
+// Calling it again with the same env var closes the previous proxy.
+func (c *Core) ProxyTester(envVar string, port int) (*Proxy, error) {
+	upstream := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	upstreamFunc := func() (string, error) { return upstream, nil }
+	p, err := newProxy(c.log, envVar, net.JoinHostPort(os.Getenv("TESTER_ADDRESS"), "0"), upstreamFunc)
+	if err != nil {
+		c.log.Logf("Can't listen on TESTER_ADDRESS for the proxy to the tester's port %d, listening on all the interfaces: %s", port, err)
+		p, err = newProxy(c.log, envVar, ":0", upstreamFunc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't start the proxy to the tester's port %d: %w", port, err)
+	}
+	os.Setenv(envVar, strconv.Itoa(p.Port()))
+
+	c.mtx.Lock()
+	previous := c.proxies["tester "+envVar]
+	c.proxies["tester "+envVar] = p
+	c.mtx.Unlock()
+
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			return p, fmt.Errorf("can't close the previous proxy for %s: %w", envVar, err)
+		}
+	}
+	return p, nil
+}
+
+// validateProxiedPorts checks that the proxied ports are container ports of the service
+func validateProxiedPorts(proxied, ports []string) error {
+	exposed, _, err := nat.ParsePortSpecs(ports)
+	if err != nil {
+		return fmt.Errorf("can't parse port specs: %w", err)
+	}
+	for _, port := range proxied {
+		if _, ok := exposed[nat.Port(normalizePort(port))]; !ok {
+			return fmt.Errorf("proxied port %s is not one of the ports of the service", port)
+		}
+	}
+	return nil
+}
+
+// startProxies starts the proxies for the `proxied_ports` of a service, unless they were already started by a previous run.
+// It should be called holding the mutex.
+func (c *Core) startProxies(name string, svc Service) error {
+	for _, port := range svc.ProxiedPorts {
+		port := normalizePort(port)
+		key := name + " " + port
+		if _, ok := c.proxies[key]; ok {
+			continue
+		}
+
+		p, err := newProxy(c.log, key, "127.0.0.1:0", func() (string, error) {
+			runner, err := c.running(name)
+			if err != nil {
+				return "", err
+			}
+			return runner.endpoint(port)
+		})
+		if err != nil {
+			return fmt.Errorf("can't start the proxy for port %s of %q: %w", port, name, err)
+		}
+		c.proxies[key] = p
+		c.log.Logf("Proxying port %s of %q on %s", port, name, p.Addr())
+	}
+	return nil
+}
+
+// closeProxies closes all the proxies started
+func (c *Core) closeProxies() error {
+	c.mtx.Lock()
+	proxies := c.proxies
+	c.proxies = map[string]*Proxy{}
+	c.mtx.Unlock()
+
+	var errs []error
+	for name, p := range proxies {
+		if err := p.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("can't close proxy %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,292 @@
+package aceptadora
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer is a TCP server echoing back everything it reads,
+// which closes its side of each connection once the client closes its own.
+type echoServer struct {
+	listener net.Listener
+	// eof receives the connections that were closed by the client
+	eof chan struct{}
+}
+
+func newEchoServer(t *testing.T) *echoServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &echoServer{listener: listener, eof: make(chan struct{}, 16)}
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	var conns []net.Conn
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mtx.Lock()
+			conns = append(conns, conn)
+			mtx.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := io.Copy(conn, conn); err == nil {
+					s.eof <- struct{}{}
+				}
+				_ = conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		mtx.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mtx.Unlock()
+		wg.Wait()
+	})
+	return s
+}
+
+func (s *echoServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+// newTestProxy starts a proxy to a new echo server
+func newTestProxy(t *testing.T) (*Proxy, *echoServer) {
+	echo := newEchoServer(t)
+	p := NewProxy(t, "echo", "127.0.0.1:0", echo.addr())
+	t.Cleanup(func() { p.Close() })
+	return p, echo
+}
+
+func dialProxy(t *testing.T, p *Proxy) *net.TCPConn {
+	conn, err := net.Dial("tcp", p.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn.(*net.TCPConn)
+}
+
+// roundTrip sends the message and reads it back, failing if it takes more than a few seconds
+func roundTrip(t *testing.T, conn net.Conn, msg string) {
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(buf))
+}
+
+// readErr returns the error of reading from the connection, or nil if it didn't fail in the timeout provided
+func readErr(conn net.Conn, timeout time.Duration) error {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err := conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	return err
+}
+
+func TestProxy_ForwardsWithoutToxics(t *testing.T) {
+	p, _ := newTestProxy(t)
+	conn := dialProxy(t, p)
+	roundTrip(t, conn, "hello")
+	roundTrip(t, conn, "world")
+}
+
+func TestProxy_Latency(t *testing.T) {
+	p, _ := newTestProxy(t)
+	p.SetToxics(Toxics{Latency: 100 * time.Millisecond})
+	conn := dialProxy(t, p)
+
+	start := time.Now()
+	roundTrip(t, conn, "hello")
+	// the latency is added in both directions
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestProxy_Bandwidth(t *testing.T) {
+	p, _ := newTestProxy(t)
+	p.SetToxics(Toxics{Bandwidth: 1000})
+	conn := dialProxy(t, p)
+
+	start := time.Now()
+	roundTrip(t, conn, string(make([]byte, 500)))
+	// 500 bytes at 1000 bytes per second are forwarded in 100 byte chunks every 100ms,
+	// and both directions overlap, as the echo server sends back each chunk as soon as it arrives
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestProxy_ResetPeer(t *testing.T) {
+	p, _ := newTestProxy(t)
+	existing := dialProxy(t, p)
+	roundTrip(t, existing, "hello")
+
+	p.SetToxics(Toxics{ResetPeer: true})
+	assert.ErrorIs(t, readErr(existing, 5*time.Second), syscall.ECONNRESET, "existing connection is reset")
+
+	accepted := dialProxy(t, p)
+	assert.ErrorIs(t, readErr(accepted, 5*time.Second), syscall.ECONNRESET, "new connection is reset")
+}
+
+func TestProxy_TimeoutDropsTheDataUntilRemoved(t *testing.T) {
+	p, _ := newTestProxy(t)
+	conn := dialProxy(t, p)
+	roundTrip(t, conn, "hello")
+
+	p.SetToxics(Toxics{Timeout: true})
+	_, err := conn.Write([]byte("dropped"))
+	require.NoError(t, err)
+	assert.NoError(t, readErr(conn, 200*time.Millisecond), "nothing should be received")
+
+	p.SetToxics(Toxics{})
+	roundTrip(t, conn, "hello again")
+}
+
+func TestProxy_TimeoutClose(t *testing.T) {
+	p, _ := newTestProxy(t)
+	conn := dialProxy(t, p)
+	roundTrip(t, conn, "hello")
+
+	p.SetToxics(Toxics{Timeout: true, TimeoutClose: 100 * time.Millisecond})
+	assert.ErrorIs(t, readErr(conn, 5*time.Second), io.EOF, "connection is closed")
+
+	stats := p.Stats()
+	require.Len(t, stats, 1)
+	assert.False(t, stats[0].Closed.IsZero())
+}
+
+func TestProxy_HalfOpen(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		halfOpen      bool
+		expectedClose bool
+	}{
+		{name: "closing is propagated", expectedClose: true},
+		{name: "closing is not propagated when half open", halfOpen: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, echo := newTestProxy(t)
+			conn := dialProxy(t, p)
+			roundTrip(t, conn, "hello")
+
+			p.SetToxics(Toxics{HalfOpen: tc.halfOpen})
+			require.NoError(t, conn.CloseWrite())
+
+			select {
+			case <-echo.eof:
+				assert.True(t, tc.expectedClose, "upstream shouldn't see the connection closed")
+			case <-time.After(200 * time.Millisecond):
+				assert.False(t, tc.expectedClose, "upstream should see the connection closed")
+			}
+		})
+	}
+}
+
+func TestProxy_Stats(t *testing.T) {
+	p, _ := newTestProxy(t)
+	first := dialProxy(t, p)
+	roundTrip(t, first, "hello")
+	second := dialProxy(t, p)
+	roundTrip(t, second, "hello world")
+
+	require.NoError(t, first.Close())
+	require.Eventually(t, func() bool { return !p.Stats()[0].Closed.IsZero() }, 5*time.Second, 10*time.Millisecond)
+
+	stats := p.Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, 1, stats[0].ID)
+	assert.Equal(t, first.LocalAddr().String(), stats[0].Client)
+	assert.Equal(t, int64(5), stats[0].BytesSent)
+	assert.Equal(t, int64(5), stats[0].BytesReceived)
+
+	assert.Equal(t, 2, stats[1].ID)
+	assert.Equal(t, second.LocalAddr().String(), stats[1].Client)
+	assert.Equal(t, int64(11), stats[1].BytesSent)
+	assert.Equal(t, int64(11), stats[1].BytesReceived)
+	assert.True(t, stats[1].Closed.IsZero(), "second connection is still open")
+}
+
+func TestProxy_CloseWithOpenConnections(t *testing.T) {
+	p, _ := newTestProxy(t)
+	conns := []*net.TCPConn{dialProxy(t, p), dialProxy(t, p)}
+	for _, conn := range conns {
+		roundTrip(t, conn, "hello")
+	}
+
+	require.NoError(t, p.Close())
+	for _, conn := range conns {
+		assert.Error(t, readErr(conn, 5*time.Second), "connection is closed")
+	}
+	for _, stats := range p.Stats() {
+		assert.False(t, stats.Closed.IsZero())
+	}
+
+	_, err := net.Dial("tcp", p.Addr())
+	assert.Error(t, err, "proxy doesn't accept connections anymore")
+}
+
+func TestCore_ProxyTesterClosesThePreviousProxy(t *testing.T) {
+	echo := newEchoServer(t)
+	_, port, err := net.SplitHostPort(echo.addr())
+	require.NoError(t, err)
+	testerPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	core := newTestCore(t, newFakeDocker())
+	t.Setenv("TESTER_PROXY_PORT", "")
+	first, err := core.ProxyTester("TESTER_PROXY_PORT", testerPort)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", first.listener.Addr().(*net.TCPAddr).IP.String(), "listens on TESTER_ADDRESS")
+
+	second, err := core.ProxyTester("TESTER_PROXY_PORT", testerPort)
+	require.NoError(t, err)
+	defer second.Close()
+	assert.Equal(t, strconv.Itoa(second.Port()), os.Getenv("TESTER_PROXY_PORT"))
+
+	_, err = net.Dial("tcp", first.Addr())
+	assert.Error(t, err, "previous proxy is closed")
+	roundTrip(t, dialProxy(t, second), "hello")
+}
+
+func TestCore_RunRejectsProxiedPortsThatArentPortsOfTheService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	core, err := NewCore(t, newFakePuller(), newTestConfigWithYAML(t, `services:
+  api:
+    image: docker.io/library/api:latest
+    ports:
+      - 80
+    proxied_ports:
+      - 81
+`))
+	require.NoError(t, err)
+	core.newClient = newFakeDocker().client
+
+	err = core.Run(ctx, "api")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "proxied port 81 is not one of the ports of the service")
+	_, err = core.Proxy("api", "81")
+	assert.Error(t, err, "proxy isn't started")
+}
@@ -13,13 +13,55 @@ import (
 	"github.com/distribution/reference"
 	imagetype "github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/registry"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 // ImagePullerConfig configures the pulling options for different image repositories
 type ImagePullerConfig struct {
 	Repo []RepositoryConfig
+
+	// PullPolicy is the pull policy of the images, unless their repository or their service define one.
+	// It can be `always` (default), `if-not-present` or `never`.
+	PullPolicy PullPolicy
+
+	// DockerConfigDir is the directory of the config.json of the docker CLI, used to find the credentials
+	// of the repositories without an Auth in their RepositoryConfig. Defaults to $DOCKER_CONFIG, or ~/.docker.
+	DockerConfigDir string
+	// IgnoreDockerConfig disables reading the credentials from the config of the docker CLI.
+	IgnoreDockerConfig bool
+}
+
+// PullPolicy defines when an image is pulled
+type PullPolicy string
+
+const (
+	// PullPolicyAlways pulls the image, even if it's already present
+	PullPolicyAlways PullPolicy = "always"
+	// PullPolicyIfNotPresent only pulls the image if it isn't present yet
+	PullPolicyIfNotPresent PullPolicy = "if-not-present"
+	// PullPolicyNever never pulls the image, failing if it isn't present, like when it's built locally
+	PullPolicyNever PullPolicy = "never"
+)
+
+func (p PullPolicy) validate() error {
+	switch p {
+	case "", PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever:
+		return nil
+	default:
+		return fmt.Errorf("unknown pull policy %q, should be %q, %q or %q", p, PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever)
+	}
+}
+
+// UnmarshalYAML validates the pull policy
+func (p *PullPolicy) UnmarshalYAML(node *yaml.Node) error {
+	var policy string
+	if err := node.Decode(&policy); err != nil {
+		return err
+	}
+	*p = PullPolicy(policy)
+	return p.validate()
 }
 
 // RepositoryConfig provides the details of access to a docker repository.
@@ -28,47 +70,145 @@ type RepositoryConfig struct {
 	Domain string
 	// SkipPulling can be specified if images from this domain are not intended to be pulled
 	// Useful for images previously built locally, or for local testing when repository credentials are not passed to the test
+	// Unlike PullPolicy `never`, it doesn't check whether the images are present.
 	SkipPulling bool
+	// PullPolicy is the pull policy of the images from this domain, unless their service defines one.
+	PullPolicy PullPolicy
 
 	// Auth provides the default docker library's field to authenticate and will be used for pulling.
 	// Usually Username & Password fields should be filled.
+	// If it's empty, the credentials are taken from the config of the docker CLI, see ImagePullerConfig.DockerConfigDir.
 	Auth registry.AuthConfig
 }
 
-func NewImagePuller(t *testing.T, cfg ImagePullerConfig) *ImagePullerImpl {
+func NewImagePuller(t testing.TB, cfg ImagePullerConfig) *ImagePullerImpl {
+	i := NewCoreImagePuller(t, cfg)
+	i.require = require.New(t)
+	return i
+}
+
+// NewCoreImagePuller creates an ImagePullerImpl to be used by a Core, which logs into the provided logger.
+// Its Pull method can't be used, as there's no test to fail, use TryPull instead.
+func NewCoreImagePuller(log Logger, cfg ImagePullerConfig) *ImagePullerImpl {
 	repos := make(map[string]RepositoryConfig, len(cfg.Repo))
 	for _, repo := range cfg.Repo {
 		repos[repo.Domain] = repo
 	}
 	return &ImagePullerImpl{
-		t:       t,
-		require: require.New(t),
-		cfg:     cfg,
-		repos:   repos,
+		log:       log,
+		cfg:       cfg,
+		repos:     repos,
+		newClient: newImageClient,
+
+		credentialHelper: execCredentialHelper,
 	}
 }
 
+// ImagePuller pulls images, failing the test if it can't
 type ImagePuller interface {
 	Pull(ctx context.Context, imageName string)
 }
 
+// CoreImagePuller pulls images, returning an error if it can't.
+// If it also implements `TryPullWithPolicy(ctx context.Context, imageName string, policy PullPolicy) error`,
+// like ImagePullerImpl does, that method is used to pull the images of the services defining a `pull_policy`.
+type CoreImagePuller interface {
+	TryPull(ctx context.Context, imageName string) error
+}
+
+// policyPuller is implemented by the pullers supporting a pull policy
+type policyPuller interface {
+	TryPullWithPolicy(ctx context.Context, imageName string, policy PullPolicy) error
+}
+
+// pullImage pulls the image with the provided policy, if the puller supports it and a policy is provided
+func pullImage(ctx context.Context, puller CoreImagePuller, imageName string, policy PullPolicy) error {
+	if p, ok := puller.(policyPuller); ok && policy != "" {
+		return p.TryPullWithPolicy(ctx, imageName, policy)
+	}
+	return puller.TryPull(ctx, imageName)
+}
+
+// corePuller returns the CoreImagePuller implemented by the ImagePuller,
+// or an adapter that always succeeds, as the ImagePuller fails the test by itself.
+func corePuller(puller ImagePuller) CoreImagePuller {
+	if p, ok := puller.(CoreImagePuller); ok {
+		return p
+	}
+	return failFastPuller{puller}
+}
+
+type failFastPuller struct {
+	puller ImagePuller
+}
+
+func (p failFastPuller) TryPull(ctx context.Context, imageName string) error {
+	p.puller.Pull(ctx, imageName)
+	return nil
+}
+
 type ImagePullerImpl struct {
-	t       *testing.T
+	// require is nil when the puller is created by NewCoreImagePuller
 	require *require.Assertions
+	log     Logger
 
 	images sync.Map
 	cfg    ImagePullerConfig
 	repos  map[string]RepositoryConfig
+
+	// newClient creates the docker client used to pull the images
+	newClient func() (imageClient, error)
+
+	// credentialHelper runs a docker credential helper to get the credentials of a registry
+	credentialHelper func(ctx context.Context, helper, serverAddress string) ([]byte, error)
+	dockerConfigOnce sync.Once
+	dockerConfig     dockerConfig
+	dockerConfigErr  error
 }
 
 func (i *ImagePullerImpl) Pull(ctx context.Context, imageName string) {
-	imi, _ := i.images.LoadOrStore(imageName, &image{})
+	err := i.TryPull(ctx, imageName)
+	i.require.NoError(err, "Can't pull image %q: %s", imageName, err)
+}
+
+// TryPull pulls the image once according to the pull policy of its repository, or the default one,
+// returning the error of that first attempt on the next calls.
+func (i *ImagePullerImpl) TryPull(ctx context.Context, imageName string) error {
+	return i.TryPullWithPolicy(ctx, imageName, "")
+}
+
+// TryPullWithPolicy is like TryPull, but using the provided pull policy if it's not empty.
+// Images are pulled once for each policy, so an image pulled with `if-not-present` is pulled again with `always`.
+func (i *ImagePullerImpl) TryPullWithPolicy(ctx context.Context, imageName string, policy PullPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	policy = i.pullPolicy(imageName, policy)
+
+	imi, _ := i.images.LoadOrStore(string(policy)+" "+imageName, &image{})
 	im := imi.(*image)
 
 	im.Do(func() {
-		im.err = i.tryPullImage(ctx, imageName)
+		im.err = i.tryPullImage(ctx, imageName, policy)
 	})
-	i.require.NoError(im.err, "Can't pull image %q: %s", imageName, im.err)
+	return im.err
+}
+
+// pullPolicy returns the pull policy of the image, which is the provided one unless it's empty,
+// then the one of its repository, and then the default one.
+func (i *ImagePullerImpl) pullPolicy(imageName string, policy PullPolicy) PullPolicy {
+	if policy != "" {
+		return policy
+	}
+	if ref, err := reference.ParseNamed(imageName); err == nil {
+		if repoCfg := i.repos[reference.Domain(ref)]; repoCfg.PullPolicy != "" {
+			return repoCfg.PullPolicy
+		}
+	}
+	if i.cfg.PullPolicy != "" {
+		return i.cfg.PullPolicy
+	}
+	return PullPolicyAlways
 }
 
 type image struct {
@@ -76,7 +216,7 @@ type image struct {
 	err error
 }
 
-func (i *ImagePullerImpl) tryPullImage(ctx context.Context, imageName string) error {
+func (i *ImagePullerImpl) tryPullImage(ctx context.Context, imageName string, policy PullPolicy) error {
 	t0 := time.Now()
 	ref, err := reference.ParseNamed(imageName)
 	if err != nil {
@@ -84,24 +224,36 @@ func (i *ImagePullerImpl) tryPullImage(ctx context.Context, imageName string) er
 	}
 	domain := reference.Domain(ref)
 
-	repoCfg, ok := i.repos[domain]
+	repoCfg := i.repos[domain]
 	if repoCfg.SkipPulling {
-		i.t.Logf("Not pulling %s: disabled by config for domain %s", imageName, domain)
+		i.log.Logf("Not pulling %s: disabled by config for domain %s", imageName, domain)
 		return nil
 	}
+	if err := policy.validate(); err != nil {
+		return fmt.Errorf("invalid pull policy for domain %s: %w", domain, err)
+	}
 
-	var authStr string
-	if ok {
-		encodedJSON, err := json.Marshal(repoCfg.Auth)
-		if err != nil {
-			return fmt.Errorf("encoding JSON auth: %v", err)
+	cli, err := i.newClient()
+	if err != nil {
+		return fmt.Errorf("creating docker client: %v", err)
+	}
+
+	if policy == PullPolicyIfNotPresent || policy == PullPolicyNever {
+		_, _, err := cli.ImageInspectWithRaw(ctx, imageName)
+		switch {
+		case err == nil:
+			i.log.Logf("Not pulling %s: it's already present and its pull policy is %s", imageName, policy)
+			return nil
+		case !errdefs.IsNotFound(err):
+			return fmt.Errorf("can't inspect image %s: %w", imageName, err)
+		case policy == PullPolicyNever:
+			return fmt.Errorf("image %s is not present and its pull policy is %s: build it or pull it before running the tests", imageName, policy)
 		}
-		authStr = base64.URLEncoding.EncodeToString(encodedJSON)
 	}
 
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	authStr, err := i.registryAuth(ctx, domain, repoCfg)
 	if err != nil {
-		return fmt.Errorf("creating docker client: %v", err)
+		return fmt.Errorf("can't pull image %s: %w", imageName, err)
 	}
 
 	out, err := cli.ImagePull(ctx, imageName, imagetype.PullOptions{RegistryAuth: authStr})
@@ -110,12 +262,37 @@ func (i *ImagePullerImpl) tryPullImage(ctx context.Context, imageName string) er
 	}
 	defer out.Close()
 
-	i.t.Logf("Pulled image %q in %s", imageName, time.Since(t0))
+	i.log.Logf("Pulled image %q in %s", imageName, time.Since(t0))
 
 	_, _ = io.Copy(
-		testLogsWriter{i.t, fmt.Sprintf("Image %q puller", imageName)},
+		testLogsWriter{i.log, fmt.Sprintf("Image %q puller", imageName)},
 		out,
 	)
 
 	return nil
 }
+
+// registryAuth returns the encoded credentials to pull from the domain: the Auth of its RepositoryConfig,
+// or the ones from the config of the docker CLI, or none.
+func (i *ImagePullerImpl) registryAuth(ctx context.Context, domain string, repoCfg RepositoryConfig) (string, error) {
+	auth := repoCfg.Auth
+	if auth == (registry.AuthConfig{}) {
+		if i.cfg.IgnoreDockerConfig {
+			return "", nil
+		}
+		dockerAuth, found, err := i.dockerConfigAuth(ctx, domain)
+		if err != nil {
+			return "", err
+		} else if !found {
+			return "", nil
+		}
+		i.log.Logf("Using the credentials for %s from the docker config", domain)
+		auth = dockerAuth
+	}
+
+	encodedJSON, err := json.Marshal(auth)
+	if err != nil {
+		return "", fmt.Errorf("encoding JSON auth: %v", err)
+	}
+	return base64.URLEncoding.EncodeToString(encodedJSON), nil
+}
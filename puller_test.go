@@ -0,0 +1,290 @@
+package aceptadora
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	imagetype "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeImages is an in-memory docker image store implementing imageClient
+type fakeImages struct {
+	mtx     sync.Mutex
+	present map[string]bool
+	pulled  []string
+	// auths are the RegistryAuth of each pull
+	auths []string
+}
+
+func (f *fakeImages) client() (imageClient, error) {
+	return f, nil
+}
+
+func (f *fakeImages) ImageInspectWithRaw(_ context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if !f.present[imageID] {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("no such image: %s", imageID))
+	}
+	return types.ImageInspect{ID: imageID}, nil, nil
+}
+
+func (f *fakeImages) ImagePull(_ context.Context, refStr string, options imagetype.PullOptions) (io.ReadCloser, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.pulled = append(f.pulled, refStr)
+	f.auths = append(f.auths, options.RegistryAuth)
+	return io.NopCloser(strings.NewReader(`{"status":"Pulled"}`)), nil
+}
+
+func TestImagePuller_PullPolicy(t *testing.T) {
+	const (
+		present = "docker.io/library/present:latest"
+		absent  = "docker.io/library/absent:latest"
+	)
+
+	for _, tc := range []struct {
+		name       string
+		cfg        ImagePullerConfig
+		policy     PullPolicy
+		image      string
+		expectErr  string
+		expectPull bool
+	}{
+		{name: "always by default", image: present, expectPull: true},
+		{name: "always", policy: PullPolicyAlways, image: present, expectPull: true},
+		{name: "if-not-present when present", policy: PullPolicyIfNotPresent, image: present},
+		{name: "if-not-present when absent", policy: PullPolicyIfNotPresent, image: absent, expectPull: true},
+		{name: "never when present", policy: PullPolicyNever, image: present},
+		{name: "never when absent", policy: PullPolicyNever, image: absent, expectErr: "is not present and its pull policy is never"},
+		{name: "unknown", policy: "sometimes", image: present, expectErr: `unknown pull policy "sometimes"`},
+		{
+			name:  "default from config",
+			cfg:   ImagePullerConfig{PullPolicy: PullPolicyNever},
+			image: absent, expectErr: "is not present",
+		},
+		{
+			name:  "repository overrides config",
+			cfg:   ImagePullerConfig{PullPolicy: PullPolicyNever, Repo: []RepositoryConfig{{Domain: "docker.io", PullPolicy: PullPolicyIfNotPresent}}},
+			image: absent, expectPull: true,
+		},
+		{
+			name:   "service overrides repository",
+			cfg:    ImagePullerConfig{Repo: []RepositoryConfig{{Domain: "docker.io", PullPolicy: PullPolicyNever}}},
+			policy: PullPolicyAlways, image: present, expectPull: true,
+		},
+		{
+			name:  "skip pulling",
+			cfg:   ImagePullerConfig{Repo: []RepositoryConfig{{Domain: "docker.io", SkipPulling: true}}},
+			image: absent,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+			images := &fakeImages{present: map[string]bool{present: true}}
+			puller := NewCoreImagePuller(t, tc.cfg)
+			puller.newClient = images.client
+
+			err := puller.TryPullWithPolicy(context.Background(), tc.image, tc.policy)
+			if tc.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tc.expectPull {
+				assert.Equal(t, []string{tc.image}, images.pulled)
+			} else {
+				assert.Empty(t, images.pulled)
+			}
+		})
+	}
+}
+
+func TestImagePuller_PullsOncePerPolicy(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	const image = "docker.io/library/redis:latest"
+	images := &fakeImages{present: map[string]bool{image: true}}
+	puller := NewCoreImagePuller(t, ImagePullerConfig{})
+	puller.newClient = images.client
+
+	ctx := context.Background()
+	require.NoError(t, puller.TryPull(ctx, image))
+	require.NoError(t, puller.TryPullWithPolicy(ctx, image, PullPolicyAlways))
+	require.NoError(t, puller.TryPullWithPolicy(ctx, image, PullPolicyIfNotPresent))
+	require.NoError(t, puller.TryPullWithPolicy(ctx, image, PullPolicyIfNotPresent))
+
+	assert.Equal(t, []string{image}, images.pulled)
+}
+
+func TestImagePuller_DockerConfigCredentials(t *testing.T) {
+	const dockerConfigJSON = `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "aHViLXVzZXI6aHViLXBhc3M="},
+			"https://registry.example.com/v1/": {"auth": "ZXhhbXBsZS11c2VyOmV4YW1wbGUtcGFzcw=="},
+			"helper.example.com": {"auth": "dW51c2VkOnVudXNlZA=="}
+		},
+		"credsStore": "store",
+		"credHelpers": {
+			"helper.example.com": "helper",
+			"token.example.com": "helper",
+			"broken.example.com": "broken"
+		}
+	}`
+
+	// helpers are the credentials of each fake credential helper by server address
+	helpers := map[string]map[string]string{
+		"store": {
+			"https://index.docker.io/v1/": `{"ServerURL": "https://index.docker.io/v1/", "Username": "store-user", "Secret": "store-pass"}`,
+		},
+		"helper": {
+			"helper.example.com": `{"ServerURL": "helper.example.com", "Username": "helper-user", "Secret": "helper-pass"}`,
+			"token.example.com":  `{"ServerURL": "token.example.com", "Username": "<token>", "Secret": "identity-token"}`,
+		},
+	}
+	credentialHelper := func(_ context.Context, helper, serverAddress string) ([]byte, error) {
+		creds, ok := helpers[helper]
+		if !ok {
+			return nil, errors.New("executable file not found")
+		}
+		if out, ok := creds[serverAddress]; ok {
+			return []byte(out), nil
+		}
+		return nil, errCredentialsNotFound
+	}
+
+	for _, tc := range []struct {
+		name       string
+		cfg        ImagePullerConfig
+		noConfig   bool
+		image      string
+		expectAuth registry.AuthConfig
+		expectErr  string
+	}{
+		{
+			name:       "credsStore",
+			image:      "docker.io/library/redis:latest",
+			expectAuth: registry.AuthConfig{Username: "store-user", Password: "store-pass", ServerAddress: "https://index.docker.io/v1/"},
+		},
+		{
+			name:       "auths when the credsStore doesn't have them",
+			image:      "registry.example.com/team/app:latest",
+			expectAuth: registry.AuthConfig{Username: "example-user", Password: "example-pass", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:       "credHelpers over auths",
+			image:      "helper.example.com/team/app:latest",
+			expectAuth: registry.AuthConfig{Username: "helper-user", Password: "helper-pass", ServerAddress: "helper.example.com"},
+		},
+		{
+			name:       "identity token from credHelpers",
+			image:      "token.example.com/team/app:latest",
+			expectAuth: registry.AuthConfig{IdentityToken: "identity-token", ServerAddress: "token.example.com"},
+		},
+		{
+			name:  "no credentials",
+			image: "other.example.com/team/app:latest",
+		},
+		{
+			name:      "failing credential helper",
+			image:     "broken.example.com/team/app:latest",
+			expectErr: "can't get the credentials for broken.example.com from docker-credential-broken",
+		},
+		{
+			name: "explicit repository config takes precedence",
+			cfg: ImagePullerConfig{Repo: []RepositoryConfig{{
+				Domain: "registry.example.com",
+				Auth:   registry.AuthConfig{Username: "config-user", Password: "config-pass"},
+			}}},
+			image:      "registry.example.com/team/app:latest",
+			expectAuth: registry.AuthConfig{Username: "config-user", Password: "config-pass"},
+		},
+		{
+			name:  "ignored docker config",
+			cfg:   ImagePullerConfig{IgnoreDockerConfig: true},
+			image: "registry.example.com/team/app:latest",
+		},
+		{
+			name:     "no docker config",
+			noConfig: true,
+			image:    "registry.example.com/team/app:latest",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.DockerConfigDir = t.TempDir()
+			if !tc.noConfig {
+				require.NoError(t, os.WriteFile(filepath.Join(tc.cfg.DockerConfigDir, "config.json"), []byte(dockerConfigJSON), 0o600))
+			}
+
+			images := &fakeImages{}
+			puller := NewCoreImagePuller(t, tc.cfg)
+			puller.newClient = images.client
+			puller.credentialHelper = credentialHelper
+
+			err := puller.TryPull(context.Background(), tc.image)
+			if tc.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectErr)
+				assert.Empty(t, images.pulled)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, images.auths, 1)
+
+			var auth registry.AuthConfig
+			if images.auths[0] != "" {
+				decoded, err := base64.URLEncoding.DecodeString(images.auths[0])
+				require.NoError(t, err)
+				require.NoError(t, json.Unmarshal(decoded, &auth))
+			}
+			assert.Equal(t, tc.expectAuth, auth)
+		})
+	}
+}
+
+func TestExecCredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read -r server
+if [ "$1" = get ] && [ "$server" = registry.example.com ]; then
+	echo '{"ServerURL": "registry.example.com", "Username": "user", "Secret": "pass"}'
+	exit 0
+fi
+echo 'credentials not found in native keychain'
+exit 1
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx := context.Background()
+	out, err := execCredentialHelper(ctx, "fake", "registry.example.com")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ServerURL": "registry.example.com", "Username": "user", "Secret": "pass"}`, string(out))
+
+	_, err = execCredentialHelper(ctx, "fake", "other.example.com")
+	assert.ErrorIs(t, err, errCredentialsNotFound)
+
+	_, err = execCredentialHelper(ctx, "missing", "registry.example.com")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errCredentialsNotFound)
+}
@@ -0,0 +1,193 @@
+package aceptadora
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	reaperPort          = "8080/tcp"
+	reaperRetryInterval = 100 * time.Millisecond
+
+	// the defaults of ReaperConfig, used when it's not loaded by envconfig
+	defaultReaperImage               = "docker.io/testcontainers/ryuk:0.11.0"
+	defaultReaperDockerSocket        = "/var/run/docker.sock"
+	defaultReaperStartTimeout        = time.Minute
+	defaultReaperReconnectionTimeout = 10 * time.Second
+
+	// reaperLabel marks the reaper container, so ryuk doesn't remove itself although it's labelled with LabelRun
+	reaperLabel = "org.testcontainers.ryuk"
+)
+
+// ReaperConfig configures the reaper: a sidecar container that removes all the containers and networks created by
+// an Aceptadora once the connection to the test process drops, even if the test process panics, times out or is killed.
+// The reaper implements the protocol of testcontainers' ryuk.
+// The fields that aren't provided take their default values, even when it's not loaded by envconfig.
+type ReaperConfig struct {
+	// Enabled starts the reaper before running the first service.
+	Enabled bool `default:"false"`
+	// Image is the image of the reaper, which should implement the protocol of testcontainers' ryuk.
+	Image string `default:"docker.io/testcontainers/ryuk:0.11.0"`
+	// DockerSocket is the path of the docker socket on the docker host, which is mounted in the reaper.
+	DockerSocket string `default:"/var/run/docker.sock"`
+	// Privileged runs the reaper as a privileged container, which might be needed to access the docker socket, like with SELinux.
+	Privileged bool `default:"false"`
+	// StartTimeout is the time the reaper has to start and accept our connection.
+	StartTimeout time.Duration `default:"1m"`
+	// ReconnectionTimeout is the time the reaper waits for the test process to reconnect before removing the resources.
+	ReconnectionTimeout time.Duration `default:"10s"`
+}
+
+// withDefaults returns the config with the defaults for the fields that weren't provided
+func (cfg ReaperConfig) withDefaults() ReaperConfig {
+	if cfg.Image == "" {
+		cfg.Image = defaultReaperImage
+	}
+	if cfg.DockerSocket == "" {
+		cfg.DockerSocket = defaultReaperDockerSocket
+	}
+	if cfg.StartTimeout <= 0 {
+		cfg.StartTimeout = defaultReaperStartTimeout
+	}
+	if cfg.ReconnectionTimeout <= 0 {
+		cfg.ReconnectionTimeout = defaultReaperReconnectionTimeout
+	}
+	return cfg
+}
+
+// newRunID returns a random identifier for the resources created by an Aceptadora
+func newRunID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startReaper starts the reaper if it's enabled and it wasn't started yet.
+// If it couldn't be started, the same error is returned by the following calls.
+func (c *Core) startReaper(ctx context.Context) error {
+	if !c.cfg.Reaper.Enabled {
+		return nil
+	}
+	c.reaperOnce.Do(func() {
+		c.reaper, c.reaperErr = c.connectReaper(ctx)
+	})
+	return c.reaperErr
+}
+
+// connectReaper creates the reaper container and connects to it, keeping the connection open until the process ends.
+func (c *Core) connectReaper(ctx context.Context) (net.Conn, error) {
+	cfg := c.cfg.Reaper.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, cfg.StartTimeout)
+	defer cancel()
+
+	if err := c.imagePuller.TryPull(ctx, cfg.Image); err != nil {
+		return nil, fmt.Errorf("can't pull image %q: %w", cfg.Image, err)
+	}
+
+	cli, err := c.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("creating docker client: %w", err)
+	}
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs([]string{reaperPort})
+	if err != nil {
+		return nil, err
+	}
+	created, err := cli.ContainerCreate(ctx,
+		&container.Config{
+			Image:        cfg.Image,
+			ExposedPorts: exposedPorts,
+			Env: []string{
+				fmt.Sprintf("RYUK_CONNECTION_TIMEOUT=%s", cfg.StartTimeout),
+				fmt.Sprintf("RYUK_RECONNECTION_TIMEOUT=%s", cfg.ReconnectionTimeout),
+			},
+			Labels: map[string]string{
+				LabelSession: c.cfg.SessionID,
+				LabelRun:     c.runID,
+				reaperLabel:  "true",
+			},
+		},
+		&container.HostConfig{
+			PortBindings: portBindings,
+			Binds:        []string{cfg.DockerSocket + ":/var/run/docker.sock"},
+			AutoRemove:   true,
+			Privileged:   cfg.Privileged,
+		},
+		nil,
+		nil,
+		sessionName(c.cfg.SessionID, "aceptadora-reaper-"+c.runID),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't create reaper container: %w", err)
+	}
+	if err := cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return nil, fmt.Errorf("can't start reaper container: %w", err)
+	}
+
+	inspect, err := cli.ContainerInspect(ctx, created.ID)
+	if err != nil {
+		return nil, fmt.Errorf("can't inspect reaper container: %w", err)
+	}
+	bindings := inspect.NetworkSettings.Ports[reaperPort]
+	if len(bindings) == 0 {
+		return nil, fmt.Errorf("reaper port %s is not published", reaperPort)
+	}
+	servicesAddress := c.cfg.ServicesAddress
+	if servicesAddress == "" {
+		servicesAddress = defaultServicesAddress
+	}
+	addr := net.JoinHostPort(servicesAddress, bindings[0].HostPort)
+
+	// the reaper might not be listening yet, so we retry until it acknowledges our filter
+	filter := fmt.Sprintf("label=%s=%s\n", LabelRun, c.runID)
+	for {
+		conn, err := registerReaperFilter(ctx, addr, filter)
+		if err == nil {
+			c.log.Logf("Reaper %q will remove the resources labelled %s=%s once this process ends", created.ID, LabelRun, c.runID)
+			return conn, nil
+		}
+
+		select {
+		case <-time.After(reaperRetryInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("can't connect to the reaper on %s: %w", addr, err)
+		}
+	}
+}
+
+// registerReaperFilter connects to the reaper and sends the filter of the resources to remove, waiting for the acknowledgement.
+func registerReaperFilter(ctx context.Context, addr, filter string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte(filter)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if strings.TrimSpace(ack) != "ACK" {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected response %q", ack)
+	}
+	// the connection should be kept open for the whole life of the process, so no deadline is applied anymore
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,66 @@
+package aceptadora
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCore_ReaperDefaults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// ryuk acknowledges the filters it receives, and removes the resources once the connection drops
+	ryuk, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ryuk.Close()
+	filters := make(chan string, 1)
+	go func() {
+		conn, err := ryuk.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		filter, _ := bufio.NewReader(conn).ReadString('\n')
+		filters <- filter
+		_, _ = conn.Write([]byte("ACK\n"))
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+	_, ryukPort, err := net.SplitHostPort(ryuk.Addr().String())
+	require.NoError(t, err)
+
+	docker := newFakeDocker()
+	docker.hostPort = func(name string, port nat.Port) string {
+		if strings.HasPrefix(name, "aceptadora-reaper-") {
+			return ryukPort
+		}
+		return "30000"
+	}
+	puller := newFakePuller()
+
+	// a Config built in code doesn't have the defaults of the envconfig tags
+	core, err := NewCore(t, puller, Config{YAMLDir: newTestConfig(t).YAMLDir, Reaper: ReaperConfig{Enabled: true}})
+	require.NoError(t, err)
+	core.newClient = docker.client
+
+	require.NoError(t, core.Run(ctx, "api"))
+	defer core.reaper.Close()
+	assert.Equal(t, "label="+LabelRun+"="+core.runID+"\n", <-filters)
+
+	reaper, ok := docker.containerNamed("aceptadora-reaper-" + core.runID)
+	require.True(t, ok, "reaper container not created")
+	assert.Equal(t, defaultReaperImage, reaper.config.Image)
+	assert.Equal(t, map[string]string{LabelSession: "", LabelRun: core.runID, reaperLabel: "true"}, reaper.config.Labels)
+	assert.Equal(t, 1, puller.pulled[defaultReaperImage])
+	assert.ElementsMatch(t, []string{"RYUK_CONNECTION_TIMEOUT=1m0s", "RYUK_RECONNECTION_TIMEOUT=10s"}, reaper.config.Env)
+	assert.Equal(t, []string{"/var/run/docker.sock:/var/run/docker.sock"}, reaper.host.Binds)
+
+	require.NoError(t, core.StopAll(ctx))
+}
@@ -0,0 +1,49 @@
+package aceptadora
+
+// RunOption modifies the definition of a service from aceptadora.yml before running it.
+type RunOption func(svc *Service)
+
+// WithEnv sets the provided env vars for the service being run.
+// They're applied on top of the ones from the service's env files and environment, so they take precedence over both.
+func WithEnv(env map[string]string) RunOption {
+	return func(svc *Service) {
+		svc.Environment = mergeConfigs(svc.Environment, env)
+	}
+}
+
+// WithCommand replaces the command of the service being run.
+func WithCommand(cmd ...string) RunOption {
+	return func(svc *Service) {
+		svc.Command = cmd
+	}
+}
+
+// WithImage replaces the image of the service being run, useful to run a different tag.
+func WithImage(image string) RunOption {
+	return func(svc *Service) {
+		svc.Image = image
+	}
+}
+
+// WithBinds replaces the binds of the service being run.
+func WithBinds(binds ...string) RunOption {
+	return func(svc *Service) {
+		svc.Binds = binds
+	}
+}
+
+// WithPorts replaces the ports of the service being run.
+func WithPorts(ports ...string) RunOption {
+	return func(svc *Service) {
+		svc.Ports = ports
+	}
+}
+
+// WithContainerName sets the name of the container of the service being run.
+// The service is registered with this name, so it should be used to Stop it or to get its Endpoint,
+// and it allows running the same service from aceptadora.yml several times under different names.
+func WithContainerName(name string) RunOption {
+	return func(svc *Service) {
+		svc.ContainerName = name
+	}
+}
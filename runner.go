@@ -2,14 +2,23 @@ package aceptadora
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	networktypes "github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/require"
@@ -17,86 +26,334 @@ import (
 
 const DefaultNetwork = "acceptance-testing"
 
+// defaultServicesAddress is used by the Runner when no other services address was provided
+const defaultServicesAddress = "127.0.0.1"
+
+// healthPollInterval is the interval used to poll the health status of a container
+const healthPollInterval = 100 * time.Millisecond
+
+// Runner runs the container of a service.
+// Its exported methods fail the test if something goes wrong, while the Core uses its error-returning internals.
 type Runner struct {
-	t       *testing.T
+	// t and require are nil when the runner is created by a Core
+	t       testing.TB
 	require *require.Assertions
+	log     Logger
 
-	puller ImagePuller
+	puller CoreImagePuller
 
 	name string
 	svc  Service
 
+	// servicesAddress is the address where the tester reaches the ports published by the container
+	servicesAddress string
+	// session, if not empty, prefixes the names of the container and its network
+	session string
+	// yamlPath is the path of the aceptadora.yml the service was defined in, if any
+	yamlPath string
+	// run identifies the Aceptadora that runs this runner, if any
+	run string
+	// artifactsDir, if not empty, is where the logs of the container are written
+	artifactsDir string
+	// defaultLogMode, defaultLogBufferSize and defaultLogHistorySize are used when the service doesn't define them
+	defaultLogMode        LogMode
+	defaultLogBufferSize  int
+	defaultLogHistorySize int
+	// logColors colorizes the logs of the service in the test logs
+	logColors bool
+
 	// docker stuff
-	client           *client.Client
+	newClient        func() (dockerClient, error)
+	client           dockerClient
 	container        container.CreateResponse
 	response         types.HijackedResponse
 	logsStreamDoneCh <-chan error
+
+	// artifacts are the files where the logs are written, owned by the logs streaming goroutine
+	artifacts map[LogStream]*os.File
+	// logBuffer keeps the last logs when the log mode is `on_failure`
+	logBuffer *logBuffer
+	// logHistory keeps the last logs, so they can be waited for or asserted
+	logHistory *logHistory
+	// logHistoryStart is the cursor of the logHistory when the container was last started
+	logHistoryStart int
+
+	// mtx guards running and ports, as they're read while the runner is being started or stopped
+	mtx sync.Mutex
+	// running is true since the container is started until it's stopped
+	running bool
+	ports   nat.PortMap
 }
 
-func NewRunner(t *testing.T, name string, svc Service, puller ImagePuller) *Runner {
+func NewRunner(t testing.TB, name string, svc Service, puller ImagePuller) *Runner {
+	r := newRunner(t, name, svc, corePuller(puller))
+	r.t = t
+	r.require = require.New(t)
+	// the buffered logs are flushed when the test finishes, in case the service isn't stopped
+	t.Cleanup(r.flushLogBuffer)
+	return r
+}
+
+// newRunner creates a runner for a Core, which logs into the provided logger
+func newRunner(log Logger, name string, svc Service, puller CoreImagePuller) *Runner {
 	return &Runner{
-		t:       t,
-		require: require.New(t),
-		name:    name,
-		puller:  puller,
-		svc:     svc,
+		log:    log,
+		name:   name,
+		puller: puller,
+		svc:    svc,
+
+		servicesAddress: defaultServicesAddress,
+		logHistory:      newLogHistory(),
+		newClient:       newDockerClient,
 	}
 }
 
+// Start will start the container and wait until all the probes defined in its `wait_for` succeed.
+// If the container was already created by a previous call to Start, and it was stopped since then,
+// the same container is started again and its logs are streamed again.
 func (r *Runner) Start(ctx context.Context) {
-	r.puller.Pull(ctx, r.svc.Image)
+	err := r.start(ctx)
+	r.require.NoError(err, "Can't start %q: %s", r.name, err)
+}
+
+func (r *Runner) start(ctx context.Context) error {
+	if r.isRunning() {
+		return fmt.Errorf("container %q is already running", r.name)
+	}
+
+	restarting := r.container.ID != ""
+	if !restarting {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		if r.logMode() == LogModeOnFailure {
+			r.logBuffer = newLogBuffer(r.logBufferSize())
+		}
+		r.logHistory.limit(r.logHistorySize())
 
-	r.createDockerClient()
-	r.stopExisting(ctx)
-	r.createContainer(ctx)
-	r.networkConnect(ctx)
-	r.attachAndStreamLogs(ctx)
+		if err := pullImage(ctx, r.puller, r.svc.Image, r.svc.PullPolicy); err != nil {
+			return fmt.Errorf("can't pull image %q: %w", r.svc.Image, err)
+		}
 
-	r.startContainer(ctx)
-	r.t.Logf("Container %q started with ID %q", r.name, r.container.ID)
+		var err error
+		if r.client, err = r.newClient(); err != nil {
+			return fmt.Errorf("unable to create a docker client: %w", err)
+		}
+		if err := r.stopExisting(ctx); err != nil {
+			return err
+		}
+		if err := r.createContainer(ctx); err != nil {
+			return err
+		}
+		if err := r.copyFiles(ctx); err != nil {
+			return err
+		}
+		if err := r.connect(ctx, r.network()); err != nil {
+			return fmt.Errorf("can't connect to network %q: %w", r.network(), err)
+		}
+	} else if err := r.stopStreamingLogs(ctx); err != nil {
+		return fmt.Errorf("can't stop streaming the previous logs: %w", err)
+	}
+	// when restarting, we don't want to stream again the logs of the previous runs
+	r.logHistoryStart = r.logHistory.cursor()
+	if err := r.attachAndStreamLogs(ctx, !restarting); err != nil {
+		return err
+	}
+
+	if err := r.client.ContainerStart(ctx, r.container.ID, container.StartOptions{}); err != nil {
+		// it's not running, so stop won't close the stream
+		r.interruptStreamingLogs()
+		return fmt.Errorf("can't start container %q: %w", r.container.ID, err)
+	}
+	r.setRunning(true)
+	if err := r.inspectPorts(ctx); err != nil {
+		return err
+	}
+	r.log.Logf("Container %q started with ID %q", r.containerName(), r.container.ID)
+
+	if err := r.waitUntilReady(ctx); err != nil {
+		return fmt.Errorf("container is not ready: %w", err)
+	}
+	return nil
 }
 
-func (r *Runner) startContainer(ctx context.Context) {
-	err := r.client.ContainerStart(ctx, r.container.ID, container.StartOptions{})
-	r.require.NoError(err, "Can't start container %q for %q: %s", r.container.ID, r.name, err)
+// validate checks the parts of the service definition that can't be checked by docker
+func (r *Runner) validate() error {
+	if err := r.svc.WaitFor.validate(r.svc.Ports); err != nil {
+		return fmt.Errorf("invalid wait_for: %w", err)
+	}
+	if err := validateProxiedPorts(r.svc.ProxiedPorts, r.svc.Ports); err != nil {
+		return fmt.Errorf("invalid proxied_ports: %w", err)
+	}
+	if err := r.logMode().validate(); err != nil {
+		return fmt.Errorf("invalid log_mode: %w", err)
+	}
+	if err := r.svc.LogFormat.validate(); err != nil {
+		return fmt.Errorf("invalid log_format: %w", err)
+	}
+	return nil
 }
 
-func (r *Runner) createDockerClient() {
-	var err error
-	r.client, err = client.NewClientWithOpts(client.FromEnv)
-	r.require.NoError(err, "Unable to create a docker client: %v", err)
+// isRunning returns true if the runner was started and not stopped since then.
+// It can be called on a nil runner.
+func (r *Runner) isRunning() bool {
+	if r == nil {
+		return false
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.running
+}
+
+func (r *Runner) setRunning(running bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.running = running
+}
+
+// Restart will stop the container within the context provided, and then start it again.
+func (r *Runner) Restart(ctx context.Context) {
+	err := r.Stop(ctx)
+	r.require.NoError(err, "Can't stop %q: %s", r.name, err)
+	r.Start(ctx)
+}
+
+// RestartWithTimeout will stop the container within the given timeout (if 0 it's just a force stop), and then start it again.
+func (r *Runner) RestartWithTimeout(ctx context.Context, timeout time.Duration) {
+	err := r.StopWithTimeout(ctx, timeout)
+	r.require.NoError(err, "Can't stop %q: %s", r.name, err)
+	r.Start(ctx)
+}
+
+// Pause suspends all the processes of the container.
+func (r *Runner) Pause(ctx context.Context) {
+	err := r.pause(ctx)
+	r.require.NoError(err, "Can't pause %q: %s", r.name, err)
+}
+
+func (r *Runner) pause(ctx context.Context) error {
+	return r.client.ContainerPause(ctx, r.container.ID)
+}
+
+// Unpause resumes all the processes of the container previously paused.
+func (r *Runner) Unpause(ctx context.Context) {
+	err := r.unpause(ctx)
+	r.require.NoError(err, "Can't unpause %q: %s", r.name, err)
+}
+
+func (r *Runner) unpause(ctx context.Context) error {
+	return r.client.ContainerUnpause(ctx, r.container.ID)
+}
+
+// Kill sends the provided signal, like "SIGTERM" or "SIGHUP", to the main process of the container.
+// If the signal is empty, "SIGKILL" is sent.
+// If the container exits because of the signal, it can still be started again with Start or Restart.
+func (r *Runner) Kill(ctx context.Context, signal string) {
+	err := r.kill(ctx, signal)
+	r.require.NoError(err, "Can't send signal %q to %q: %s", signal, r.name, err)
+}
+
+func (r *Runner) kill(ctx context.Context, signal string) error {
+	return r.client.ContainerKill(ctx, r.container.ID, signal)
+}
+
+// inspectPorts reads the host ports the container ports were published on.
+// This is needed as container ports defined without a host port (like `- 6379`) are published on a random host port.
+func (r *Runner) inspectPorts(ctx context.Context) error {
+	inspect, err := r.client.ContainerInspect(ctx, r.container.ID)
+	if err != nil {
+		return fmt.Errorf("can't inspect container %q: %w", r.container.ID, err)
+	}
+	if inspect.NetworkSettings != nil {
+		r.mtx.Lock()
+		r.ports = inspect.NetworkSettings.Ports
+		r.mtx.Unlock()
+	}
+	return nil
+}
+
+// Endpoint returns the address where the tester can reach the given container port, like `6379/tcp`.
+// If the protocol is not provided, `tcp` is assumed.
+// The address combines the services address with the host port the container port is published on.
+func (r *Runner) Endpoint(port string) string {
+	addr, err := r.endpoint(port)
+	r.require.NoError(err, "Can't find the endpoint for %q: %s", r.name, err)
+	return addr
+}
+
+func (r *Runner) endpoint(port string) (string, error) {
+	port = normalizePort(port)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, binding := range r.ports[nat.Port(port)] {
+		if binding.HostPort != "" {
+			return net.JoinHostPort(r.servicesAddress, binding.HostPort), nil
+		}
+	}
+	return "", fmt.Errorf("port %s is not published", port)
+}
+
+// sessionName prefixes the name with the session, if there's one
+func sessionName(session, name string) string {
+	if session == "" {
+		return name
+	}
+	return session + "-" + name
 }
 
-func (r *Runner) stopExisting(ctx context.Context) {
+// normalizePort adds the default `tcp` protocol to the container port if it doesn't have one
+func normalizePort(port string) string {
+	if !strings.Contains(port, "/") {
+		return port + "/tcp"
+	}
+	return port
+}
+
+// stopExisting removes the container with the same name, if it was created by aceptadora.
+// It fails if there's a container with the same name that wasn't created by aceptadora, as it's not ours to remove.
+func (r *Runner) stopExisting(ctx context.Context) error {
 	listFilters := filters.NewArgs()
-	listFilters.Add("name", r.name+"$")
-	existing, _ := r.client.ContainerList(ctx, container.ListOptions{
+	listFilters.Add("name", "^/?"+regexp.QuoteMeta(r.containerName())+"$")
+	existing, err := r.client.ContainerList(ctx, container.ListOptions{
 		All:     true,
 		Filters: listFilters,
 	})
+	if err != nil {
+		return fmt.Errorf("can't list the existing containers named %q: %w", r.containerName(), err)
+	}
 
 	for _, c := range existing {
-		r.t.Logf("Removing container %s:%s", c.Names[0], c.ID)
+		if _, ok := c.Labels[LabelService]; !ok {
+			return fmt.Errorf("there's already a container named %q (%s) that wasn't created by aceptadora, refusing to remove it: "+
+				"remove it manually or use a Config.SessionID to prefix the names of the containers", r.containerName(), c.ID)
+		}
+		r.log.Logf("Removing container %s:%s", c.Names[0], c.ID)
 		if err := r.client.ContainerRemove(ctx, c.ID,
 			container.RemoveOptions{
 				RemoveVolumes: true,
 				Force:         true,
 			}); err != nil {
-			r.t.Fatalf("Can't remove container %q for %q: %s", c.ID, r.name, err)
+			return fmt.Errorf("can't remove container %q: %w", c.ID, err)
 		}
 	}
+	return nil
 }
 
-func (r *Runner) createContainer(ctx context.Context) {
+func (r *Runner) createContainer(ctx context.Context) error {
 	cfg := map[string]string{}
 	for _, f := range r.svc.EnvFile {
 		fcfg, err := loadConfigFromFile(f)
-		r.require.NoError(err, "Can't load env config for %q from %q: %s", r.name, f, err)
+		if err != nil {
+			return fmt.Errorf("can't load env config from %q: %w", f, err)
+		}
 		cfg = mergeConfigs(cfg, fcfg)
 	}
+	cfg = mergeConfigs(cfg, r.svc.Environment)
 
 	exposedPorts, portBindings, err := nat.ParsePortSpecs(r.svc.Ports)
-	r.require.NoError(err, "Can't parse port specs for %q: %s", r.name, err)
+	if err != nil {
+		return fmt.Errorf("can't parse port specs: %w", err)
+	}
 
 	r.container, err = r.client.ContainerCreate(
 		ctx,
@@ -105,6 +362,7 @@ func (r *Runner) createContainer(ctx context.Context) {
 			Env:          flatten(cfg),
 			Cmd:          r.svc.Command,
 			ExposedPorts: exposedPorts,
+			Labels:       r.containerLabels(),
 		},
 		&container.HostConfig{
 			PortBindings: portBindings,
@@ -112,38 +370,131 @@ func (r *Runner) createContainer(ctx context.Context) {
 		},
 		nil,
 		nil,
-		r.name,
+		r.containerName(),
 	)
-	r.require.NoError(err, "Can't create container %q: %s", r.name, err)
+	if err != nil {
+		return fmt.Errorf("can't create container %q: %w", r.containerName(), err)
+	}
+	return nil
+}
+
+// containerName returns the name of the container, which is prefixed by the session if there's one
+func (r *Runner) containerName() string {
+	return sessionName(r.session, r.name)
 }
 
-func (r *Runner) networkConnect(ctx context.Context) {
-	network := r.svc.Network
-	if network == "" {
-		network = DefaultNetwork
+// network returns the network the container is connected to when it's created, which is prefixed by the session if there's one
+func (r *Runner) network() string {
+	if r.svc.Network == "" {
+		return sessionName(r.session, DefaultNetwork)
 	}
+	return sessionName(r.session, r.svc.Network)
+}
 
+// Connect connects the container to the provided network, creating the network if it doesn't exist.
+// The container can be reached on that network by the runner's name, which doesn't include the session prefix.
+func (r *Runner) Connect(ctx context.Context, network string) {
+	err := r.connect(ctx, network)
+	r.require.NoError(err, "Can't connect %q to network %q: %s", r.name, network, err)
+}
+
+// Disconnect disconnects the container from the provided network,
+// so it can't reach the other containers on that network, nor be reached by them.
+func (r *Runner) Disconnect(ctx context.Context, network string) {
+	err := r.disconnect(ctx, network)
+	r.require.NoError(err, "Can't disconnect %q from network %q: %s", r.name, network, err)
+}
+
+func (r *Runner) disconnect(ctx context.Context, network string) error {
+	return r.client.NetworkDisconnect(ctx, network, r.container.ID, true)
+}
+
+func (r *Runner) connect(ctx context.Context, network string) error {
 	if _, err := r.client.NetworkInspect(ctx, network, types.NetworkInspectOptions{}); err != nil && client.IsErrNotFound(err) {
-		_, err := r.client.NetworkCreate(ctx, network, types.NetworkCreate{})
-		r.require.NoError(err, "Can't create network %q for container %q: %s", network, r.name, err)
+		// it might have been created concurrently by another runner, in which case it's a conflict
+		if _, err := r.client.NetworkCreate(ctx, network, types.NetworkCreate{Labels: r.networkLabels()}); err != nil && !errdefs.IsConflict(err) {
+			return fmt.Errorf("can't create network: %w", err)
+		}
 	}
-	err := r.client.NetworkConnect(ctx, network, r.container.ID, nil)
-	r.require.NoError(err, "Can't connect %q to network %q: %s", r.name, network, err)
+	return r.client.NetworkConnect(ctx, network, r.container.ID, &networktypes.EndpointSettings{
+		Aliases: []string{r.name},
+	})
 }
 
-func (r *Runner) attachAndStreamLogs(ctx context.Context) {
-	if r.svc.IgnoreLogs {
-		return
+// attachAndStreamLogs streams the logs of the container, including the previous ones if history is true.
+// Logs are streamed even if they're never logged, as they're kept for WaitForLog and AssertNoLog.
+func (r *Runner) attachAndStreamLogs(ctx context.Context, history bool) error {
+	if err := r.openArtifacts(); err != nil {
+		return fmt.Errorf("can't open the log files in %q: %w", r.artifactsDir, err)
 	}
+
 	var err error
 	r.response, err = r.client.ContainerAttach(ctx, r.container.ID, container.AttachOptions{
 		Stream: true,
 		Stdout: true,
 		Stderr: true,
-		Logs:   true,
+		Logs:   history,
 	})
-	r.require.NoError(err, "Can't can't stream logs from %q: %s", r.name, err)
+	if err != nil {
+		r.closeArtifacts()
+		return fmt.Errorf("can't stream logs: %w", err)
+	}
 	r.logsStreamDoneCh = r.streamLogs(r.response)
+	return nil
+}
+
+// WaitHealthy waits until the docker HEALTHCHECK of the container reports it as healthy.
+// It returns an error if the container has no healthcheck defined, or if it becomes unhealthy.
+func (r *Runner) WaitHealthy(ctx context.Context) error {
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := r.healthStatus(ctx)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case types.Healthy:
+			return nil
+		case types.Unhealthy:
+			return fmt.Errorf("container %q is unhealthy", r.name)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("container %q didn't become healthy: %w", r.name, ctx.Err())
+		}
+	}
+}
+
+// healthStatus returns the status reported by the docker HEALTHCHECK of the container
+func (r *Runner) healthStatus(ctx context.Context) (string, error) {
+	inspect, err := r.client.ContainerInspect(ctx, r.container.ID)
+	if err != nil {
+		return "", fmt.Errorf("can't inspect container %q: %w", r.name, err)
+	}
+	if inspect.State.Health == nil || inspect.State.Health.Status == types.NoHealthcheck {
+		return "", fmt.Errorf("container %q has no healthcheck defined", r.name)
+	}
+	return inspect.State.Health.Status, nil
+}
+
+// WaitCompleted waits until the container exits, and returns an error if its exit code isn't zero.
+func (r *Runner) WaitCompleted(ctx context.Context) error {
+	resultCh, errCh := r.client.ContainerWait(ctx, r.container.ID, container.WaitConditionNotRunning)
+	select {
+	case res := <-resultCh:
+		if res.Error != nil {
+			return fmt.Errorf("can't wait for container %q: %s", r.name, res.Error.Message)
+		}
+		if res.StatusCode != 0 {
+			return fmt.Errorf("container %q exited with code %d", r.name, res.StatusCode)
+		}
+		return nil
+	case err := <-errCh:
+		return fmt.Errorf("can't wait for container %q: %w", r.name, err)
+	}
 }
 
 // Stop will try to stop the container within the context provided.
@@ -157,49 +508,75 @@ func (r *Runner) StopWithTimeout(ctx context.Context, timeout time.Duration) err
 }
 
 func (r *Runner) stop(ctx context.Context, timeout *time.Duration) error {
-	if r == nil || r.client == nil {
+	if r == nil || r.client == nil || !r.isRunning() {
 		// nothing to stop
 		return nil
 	}
 
-	timeoutSeconds := int(timeout.Seconds())
-	stopOpts := container.StopOptions{Timeout: &timeoutSeconds}
+	var stopOpts container.StopOptions
+	if timeout != nil {
+		timeoutSeconds := int(timeout.Seconds())
+		stopOpts.Timeout = &timeoutSeconds
+	}
+	var stopErr error
 	if err := r.client.ContainerStop(ctx, r.container.ID, stopOpts); err != nil {
-		r.t.Errorf("Error stopping container %s: %v", r.container.ID, err)
+		stopErr = fmt.Errorf("error stopping container %s: %w", r.container.ID, err)
 	}
+	r.setRunning(false)
 
-	var err error
-	if r.logsStreamDoneCh != nil {
-		select {
-		case err = <-r.logsStreamDoneCh:
-		case <-ctx.Done():
-			err = ctx.Err()
-		}
-		if err != nil {
-			r.t.Errorf("Error interrupting streaming of logs from %s: %v", r.container.ID, err)
-		}
+	err := r.stopStreamingLogs(ctx)
+	r.flushLogBuffer()
+	return errors.Join(stopErr, err)
+}
 
+// stopStreamingLogs waits until the logs stream finishes, which happens once the container is stopped, and closes it.
+// If the context is done first, the stream is interrupted instead.
+func (r *Runner) stopStreamingLogs(ctx context.Context) error {
+	if r.logsStreamDoneCh == nil {
+		return nil
+	}
+
+	var err error
+	select {
+	case err = <-r.logsStreamDoneCh:
 		r.response.Close()
+		r.logsStreamDoneCh = nil
+	case <-ctx.Done():
+		err = ctx.Err()
+		r.interruptStreamingLogs()
+	}
+	if err != nil {
+		err = fmt.Errorf("error interrupting streaming of logs from %s: %w", r.container.ID, err)
 	}
 	return err
 }
 
+// interruptStreamingLogs closes the logs stream, and waits until its goroutine finishes,
+// as it owns the artifacts that the next attach replaces.
+func (r *Runner) interruptStreamingLogs() {
+	r.response.Close()
+	<-r.logsStreamDoneCh
+	r.logsStreamDoneCh = nil
+}
+
 func (r *Runner) streamLogs(resp types.HijackedResponse) <-chan error {
-	done := make(chan error)
+	// buffered, so the stream can finish even if nobody waits for it, like when the container is killed
+	done := make(chan error, 1)
 
 	go func() {
-		_, err := stdcopy.StdCopy(
-			testLogsWriter{r.t, fmt.Sprintf("Container %q STDOUT", r.name)},
-			testLogsWriter{r.t, fmt.Sprintf("Container %q STDERR", r.name)},
-			resp.Reader,
-		)
+		stdout := newLineWriter(func(line string) { r.logLine(Stdout, line) })
+		stderr := newLineWriter(func(line string) { r.logLine(Stderr, line) })
+		_, err := stdcopy.StdCopy(stdout, stderr, resp.Reader)
+		stdout.Flush()
+		stderr.Flush()
+		r.closeArtifacts()
 		done <- err
 	}()
 	return done
 }
 
 type testLogsWriter struct {
-	t    *testing.T
+	t    Logger
 	name string
 }
 
@@ -218,10 +595,17 @@ func (tw testLogsWriter) Write(data []byte) (n int, err error) {
 	return len(data), nil
 }
 
+// flatten returns the config as a list of `key=value` strings, sorted by key so the containers are created deterministically
 func flatten(config map[string]string) []string {
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	flat := make([]string, 0, len(config))
-	for k, v := range config {
-		flat = append(flat, fmt.Sprintf("%s=%s", k, v))
+	for _, k := range keys {
+		flat = append(flat, fmt.Sprintf("%s=%s", k, config[k]))
 	}
 	return flat
 }
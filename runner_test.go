@@ -0,0 +1,68 @@
+package aceptadora
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_RestartAfterTheLogsStreamWasInterrupted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	// the stream doesn't end when the container is stopped, so stopping it times out waiting for the stream
+	docker.stopKeepsLogs = true
+	r := NewRunner(t, "api", Service{Image: "docker.io/library/api:latest"}, newFakePuller())
+	r.newClient = docker.client
+	r.artifactsDir = t.TempDir()
+
+	r.Start(ctx)
+	r.WaitForLog(ctx, "api started")
+	stopCtx, stopCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer stopCancel()
+	err := r.Stop(stopCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the stream of the previous run was interrupted, so it doesn't race with the new one nor close its artifacts
+	docker.stopKeepsLogs = false
+	r.Start(ctx)
+	require.Eventually(t, func() bool {
+		return len(r.LogEntries(func(e LogEntry) bool { return e.Line == "api started" })) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, r.Stop(ctx))
+
+	data, err := os.ReadFile(filepath.Join(r.artifactsDir, "api.stdout.log"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "api started"), "both runs are written into the artifacts")
+}
+
+func TestCore_RunClosesTheLogsStreamWhenTheContainerCantBeStarted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docker := newFakeDocker()
+	docker.startErr = errors.New("port is already allocated")
+	core := newTestCore(t, docker)
+
+	err := core.Run(ctx, "api")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "port is already allocated")
+
+	c, ok := docker.containerNamed("api")
+	require.True(t, ok)
+	docker.mtx.Lock()
+	logs := c.logs
+	docker.mtx.Unlock()
+	// nobody reads the stream anymore, and its other end is closed
+	_, err = logs.Write([]byte("logged after the start failed\n"))
+	assert.Error(t, err)
+	require.NoError(t, core.StopAll(ctx))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,14 +17,153 @@ type YAML struct {
 
 // Service describes a service aceptadora can run
 type Service struct {
-	Image   string   `yaml:"image"`
+	// ContainerName is the name of the container, which is also the name aceptadora registers the running service with.
+	// If empty, the name of the service is used.
+	ContainerName string `yaml:"container_name"`
+
+	Image string `yaml:"image"`
+	// PullPolicy defines when the image is pulled: `always`, `if-not-present` or `never`.
+	// If empty, the one configured in the ImagePullerConfig is used.
+	PullPolicy PullPolicy `yaml:"pull_policy"`
+
 	Network string   `yaml:"network"`
 	Binds   []string `yaml:"binds"`
 	Command []string `yaml:"command"`
 	EnvFile []string `yaml:"env_file"`
 	Ports   []string `yaml:"ports"`
 
+	// ProxiedPorts lists the container ports that the tester reaches through a Proxy, allowing to inject faults.
+	// The proxies can be obtained through Aceptadora.Proxy.
+	ProxiedPorts []string `yaml:"proxied_ports"`
+
+	// Environment defines env vars for the container.
+	// They're applied on top of the ones loaded from the EnvFile, so they take precedence.
+	Environment Environment `yaml:"environment"`
+
+	// Copy lists the files or directories copied into the container before starting it.
+	Copy []Copy `yaml:"copy"`
+
+	// WaitFor defines the readiness probes that should succeed before considering the service started.
+	WaitFor WaitFor `yaml:"wait_for"`
+
+	// DependsOn lists the services that RunAll should start before this one, and the condition they should reach.
+	DependsOn Dependencies `yaml:"depends_on"`
+
+	// IgnoreLogs is the same as LogMode `never`, kept for backwards compatibility.
 	IgnoreLogs bool `yaml:"ignore_logs"`
+
+	// LogMode defines when the logs of the container are sent to the test logs, Config.LogMode is used if empty.
+	LogMode LogMode `yaml:"log_mode"`
+	// LogBufferSize is the amount of lines kept in the buffer when LogMode is `on_failure`, Config.LogBufferSize is used if zero.
+	LogBufferSize int `yaml:"log_buffer_size"`
+	// LogHistorySize is the amount of lines kept for WaitForLog, AssertNoLog and LogEntries, Config.LogHistorySize is used if zero.
+	LogHistorySize int `yaml:"log_history_size"`
+	// LogFormat defines how the logs of the container are decoded, decoded entries are pretty printed in the test logs.
+	LogFormat LogFormat `yaml:"log_format"`
+	// LogFields are the fields of the decoded entries printed in the test logs besides the level and the message.
+	// All of them are printed if empty.
+	LogFields []string `yaml:"log_fields"`
+}
+
+// instanceName returns the name the service defined with the given name in aceptadora.yml is run with
+func (s Service) instanceName(name string) string {
+	if s.ContainerName != "" {
+		return s.ContainerName
+	}
+	return name
+}
+
+// Environment maps the env var names to their values.
+// Like in docker-compose, it can be defined either as a map, or as a list of `NAME=value` strings.
+// Variables defined without a value (like `- NAME` or `NAME:`) take their value from the environment of the tester,
+// and are ignored if it's not set there.
+type Environment map[string]string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (e *Environment) UnmarshalYAML(node *yaml.Node) error {
+	env := Environment{}
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, line := range list {
+			if k, v, ok := strings.Cut(line, "="); ok {
+				env[k] = v
+			} else if v, ok := os.LookupEnv(k); ok {
+				env[k] = v
+			}
+		}
+		*e = env
+		return nil
+	}
+
+	var values map[string]*string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	for k, v := range values {
+		if v != nil {
+			env[k] = *v
+		} else if v, ok := os.LookupEnv(k); ok {
+			env[k] = v
+		}
+	}
+	*e = env
+	return nil
+}
+
+// DependencyCondition defines the state a dependency should reach before starting the service depending on it.
+type DependencyCondition string
+
+const (
+	// DependencyConditionStarted is satisfied once the dependency has been started.
+	DependencyConditionStarted DependencyCondition = "service_started"
+	// DependencyConditionHealthy is satisfied once the dependency's docker HEALTHCHECK reports it as healthy.
+	DependencyConditionHealthy DependencyCondition = "service_healthy"
+	// DependencyConditionCompletedSuccessfully is satisfied once the dependency has exited with a zero exit code.
+	DependencyConditionCompletedSuccessfully DependencyCondition = "service_completed_successfully"
+)
+
+// Dependency describes the condition of a single service dependency
+type Dependency struct {
+	Condition DependencyCondition `yaml:"condition"`
+}
+
+// Dependencies maps the names of the services depended on to their conditions.
+// Like in docker-compose, it can be defined either as a list of service names, or as a map of service names to their conditions.
+type Dependencies map[string]Dependency
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Dependencies) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		*d = make(Dependencies, len(names))
+		for _, name := range names {
+			(*d)[name] = Dependency{Condition: DependencyConditionStarted}
+		}
+		return nil
+	}
+
+	deps := map[string]Dependency{}
+	if err := node.Decode(&deps); err != nil {
+		return err
+	}
+	for name, dep := range deps {
+		switch dep.Condition {
+		case "":
+			dep.Condition = DependencyConditionStarted
+		case DependencyConditionStarted, DependencyConditionHealthy, DependencyConditionCompletedSuccessfully:
+		default:
+			return fmt.Errorf("unknown condition %q for dependency %q", dep.Condition, name)
+		}
+		deps[name] = dep
+	}
+	*d = deps
+	return nil
 }
 
 // LoadYAML reads the aceptadora.yml config, expanding the env var references to their values.
@@ -0,0 +1,104 @@
+package aceptadora
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEnvironment_UnmarshalYAML(t *testing.T) {
+	t.Setenv("FROM_TESTER", "tester value")
+
+	for _, tc := range []struct {
+		name        string
+		yaml        string
+		expected    Environment
+		expectedErr string
+	}{
+		{
+			name:     "list",
+			yaml:     "environment: [NAME=value, EMPTY=, WITH_EQUALS=a=b]",
+			expected: Environment{"NAME": "value", "EMPTY": "", "WITH_EQUALS": "a=b"},
+		},
+		{
+			name:     "list with names without value",
+			yaml:     "environment: [NAME=value, FROM_TESTER, NOT_SET_IN_TESTER]",
+			expected: Environment{"NAME": "value", "FROM_TESTER": "tester value"},
+		},
+		{
+			name:     "map",
+			yaml:     "environment: {NAME: value, NUMBER: 1, EMPTY: ''}",
+			expected: Environment{"NAME": "value", "NUMBER": "1", "EMPTY": ""},
+		},
+		{
+			name:     "map with null values",
+			yaml:     "environment: {NAME: value, FROM_TESTER: , NOT_SET_IN_TESTER: ~}",
+			expected: Environment{"NAME": "value", "FROM_TESTER": "tester value"},
+		},
+		{
+			name: "null",
+			yaml: "environment: ~",
+		},
+		{
+			name: "not defined",
+			yaml: "image: redis",
+		},
+		{
+			name:        "neither a list nor a map",
+			yaml:        "environment: NAME=value",
+			expectedErr: "cannot unmarshal",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var svc Service
+			err := yaml.Unmarshal([]byte(tc.yaml), &svc)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, svc.Environment)
+		})
+	}
+}
+
+func TestCore_RunSetsTheEnvFromEnvFileEnvironmentAndWithEnv(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	envFile := filepath.Join(t.TempDir(), "api.env")
+	require.NoError(t, os.WriteFile(envFile, []byte("# comment\nFROM_ENV_FILE=env_file\nIN_ENVIRONMENT=env_file\nIN_ALL=env_file\n"), 0o644))
+
+	docker := newFakeDocker()
+	core, err := NewCore(t, newFakePuller(), newTestConfigWithYAML(t, fmt.Sprintf(`services:
+  api:
+    image: docker.io/library/api:latest
+    env_file:
+      - %s
+    environment:
+      IN_ENVIRONMENT: environment
+      IN_ALL: environment
+      IN_WITH_ENV: environment
+`, envFile)))
+	require.NoError(t, err)
+	core.newClient = docker.client
+
+	require.NoError(t, core.Run(ctx, "api", WithEnv(map[string]string{"IN_ALL": "with_env", "IN_WITH_ENV": "with_env"})))
+	c, ok := docker.containerNamed("api")
+	require.True(t, ok)
+	assert.Equal(t, []string{
+		"FROM_ENV_FILE=env_file",
+		"IN_ALL=with_env",
+		"IN_ENVIRONMENT=environment",
+		"IN_WITH_ENV=with_env",
+	}, c.config.Env, "sorted by name, with env_file < environment < WithEnv")
+	require.NoError(t, core.StopAll(ctx))
+}